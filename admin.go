@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const AdminPrefix string = "/admin"
+
+func IsAdminPath(r *http.Request) bool {
+	return r.URL.Path == AdminPrefix || strings.HasPrefix(r.URL.Path, AdminPrefix+"/")
+}
+
+// AdminMiddleware only lets through requests bearing token
+func AdminMiddleware(token string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// AdminRoutes registers the runtime toggles under /admin, only when an admin
+// token is configured.
+func AdminRoutes(router *mux.Router, cfg *Config) {
+	if cfg.AdminToken == "" {
+		return
+	}
+
+	admin := router.PathPrefix(AdminPrefix).Subrouter()
+	admin.Use(AdminMiddleware(cfg.AdminToken))
+
+	Handle(
+		admin,
+		"admin_maintenance",
+		"/maintenance",
+		[]string{"GET", "PUT", "DELETE"},
+		func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodPut:
+				maintenance.Set(true, "admin endpoint")
+			case http.MethodDelete:
+				maintenance.Set(false, "admin endpoint")
+			}
+			writeJSON(w, http.StatusOK, map[string]bool{"maintenance": maintenance.Enabled()})
+		},
+	)
+
+	Handle(
+		admin,
+		"admin_read_only",
+		"/read_only",
+		[]string{"GET", "PUT", "DELETE"},
+		func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodPut:
+				readOnly.Set(true, "admin endpoint")
+			case http.MethodDelete:
+				readOnly.Set(false, "admin endpoint")
+			}
+			writeJSON(w, http.StatusOK, map[string]bool{"read_only": readOnly.Enabled()})
+		},
+	)
+
+	Handle(
+		admin,
+		"admin_routes",
+		"/routes",
+		[]string{"GET"},
+		func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, RouteInfos(router, disabledRoutes))
+		},
+	)
+
+	// PUT disables the route, DELETE enables it again. The admin routes can't
+	// be disabled, or there'd be no way back.
+	Handle(
+		admin,
+		"admin_route_disabled",
+		"/routes/{name}/disabled",
+		[]string{"GET", "PUT", "DELETE"},
+		func(w http.ResponseWriter, r *http.Request) {
+			name := mux.Vars(r)["name"]
+			route := router.Get(name)
+			if route == nil || strings.HasPrefix(name, "admin_") {
+				http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+				return
+			}
+			switch r.Method {
+			case http.MethodPut:
+				disabledRoutes.Set(name, true, "admin endpoint")
+			case http.MethodDelete:
+				disabledRoutes.Set(name, false, "admin endpoint")
+			}
+			writeJSON(w, http.StatusOK, map[string]bool{"disabled": disabledRoutes.Disabled(name)})
+		},
+	)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// APIVersions are the versions of application/vnd.<vendor>.v<N>+json media
+// types served
+type APIVersions struct {
+	vendor    *regexp.Regexp
+	supported map[int]bool
+	fallback  int
+}
+
+// NewAPIVersions serves versions of vendor's media types, fallback being
+// assumed when Accept names none, the latest one when 0
+func NewAPIVersions(vendor string, versions []string, fallback int) (*APIVersions, error) {
+	v := &APIVersions{
+		vendor:    regexp.MustCompile(`^application/vnd\.` + regexp.QuoteMeta(vendor) + `\.v(\d+)(\+[a-z0-9.-]+)?$`),
+		supported: map[int]bool{},
+	}
+	for _, version := range versions {
+		n, err := strconv.Atoi(version)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("api versions: invalid version %q", version)
+		}
+		v.supported[n] = true
+		if n > v.fallback && fallback == 0 {
+			v.fallback = n
+		}
+	}
+	if fallback != 0 {
+		if !v.supported[fallback] {
+			return nil, fmt.Errorf("api versions: default version %d isn't supported", fallback)
+		}
+		v.fallback = fallback
+	}
+	return v, nil
+}
+
+// Negotiate picks the requested version with the highest q, or the default
+// one when Accept asks for no vendor type. It fails when only unsupported
+// versions are asked for.
+func (v *APIVersions) Negotiate(accept string) (int, bool) {
+	var (
+		requested bool
+		best      int
+		bestQ     float64
+	)
+	for _, ar := range parseAccept(accept) {
+		match := v.vendor.FindStringSubmatch(ar.mediaType)
+		if match == nil {
+			continue
+		}
+		requested = true
+		n, _ := strconv.Atoi(match[1])
+		if v.supported[n] && ar.q > 0 && ar.q > bestQ {
+			best, bestQ = n, ar.q
+		}
+	}
+	if !requested {
+		return v.fallback, true
+	}
+	return best, best != 0
+}
+
+// VersionFromContext is the negotiated API version, 0 when not negotiated
+func VersionFromContext(r *http.Request) int {
+	version, _ := r.Context().Value(apiVersionKey).(int)
+	return version
+}
+
+// APIVersionMiddleware negotiates the API version from the Accept header
+// into the context, answering a 406 when only unsupported versions are
+// acceptable. A nil v disables it.
+func APIVersionMiddleware(v *APIVersions) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		if v == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			version, ok := v.Negotiate(r.Header.Get("Accept"))
+			if !ok {
+				Reject(w, r, http.StatusNotAcceptable, "unsupported API version")
+				return
+			}
+			ctx := context.WithValue(r.Context(), apiVersionKey, version)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
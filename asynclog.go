@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+)
+
+type asyncLine struct {
+	b       []byte
+	flushed chan struct{}
+}
+
+// AsyncWriter queues lines for a background goroutine to write, so a log
+// destination that blocks, like a full pipe, doesn't hold up requests. Lines
+// coming in while the queue is full are dropped and counted instead. Once
+// flushed it writes synchronously, for the last lines before exiting.
+type AsyncWriter struct {
+	w     io.Writer
+	name  string
+	lines chan asyncLine
+	sync  atomic.Bool
+
+	dropped atomic.Uint64
+}
+
+// NewAsyncWriter queues up to size lines for w, name labeling its dropped
+// lines metric
+func NewAsyncWriter(w io.Writer, size int, name string) *AsyncWriter {
+	aw := &AsyncWriter{w: w, name: name, lines: make(chan asyncLine, size)}
+	go aw.run()
+	return aw
+}
+
+func (aw *AsyncWriter) run() {
+	for line := range aw.lines {
+		if line.flushed != nil {
+			close(line.flushed)
+			continue
+		}
+		aw.w.Write(line.b)
+	}
+}
+
+func (aw *AsyncWriter) Write(p []byte) (int, error) {
+	if aw.sync.Load() {
+		return aw.w.Write(p)
+	}
+	// The log package reuses its buffer once Write returns
+	b := append([]byte(nil), p...)
+	select {
+	case aw.lines <- asyncLine{b: b}:
+	default:
+		aw.dropped.Add(1)
+		logLinesDropped.Inc(aw.name)
+	}
+	return len(p), nil
+}
+
+func (aw *AsyncWriter) Dropped() uint64 {
+	return aw.dropped.Load()
+}
+
+// Flush waits for the queued lines to be written, or ctx to be done, and
+// has later ones written right away
+func (aw *AsyncWriter) Flush(ctx context.Context) error {
+	flushed := make(chan struct{})
+	select {
+	case aw.lines <- asyncLine{flushed: flushed}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-flushed:
+		aw.sync.Store(true)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// BaggageItem is one of the BAGGAGE_HEADERS a request came with
+type BaggageItem struct {
+	Name      string
+	Value     string
+	Sensitive bool
+}
+
+// Baggage are the request's BAGGAGE_HEADERS, in the configured order
+type Baggage []BaggageItem
+
+// Get is the value of the named header, "" when the request had none
+func (b Baggage) Get(name string) string {
+	name = http.CanonicalHeaderKey(name)
+	for _, item := range b {
+		if item.Name == name {
+			return item.Value
+		}
+	}
+	return ""
+}
+
+// logFields are the access log fields of the baggage, like baggage_x_tenant_id,
+// sensitive values logged as [redacted]
+func (b Baggage) logFields() []logField {
+	fields := make([]logField, 0, len(b))
+	for _, item := range b {
+		value := item.Value
+		if item.Sensitive {
+			value = Redacted
+		}
+		key := "baggage_" + strings.ToLower(strings.ReplaceAll(item.Name, "-", "_"))
+		fields = append(fields, logField{key, value})
+	}
+	return fields
+}
+
+func requestBaggage(r *http.Request) Baggage {
+	baggage, _ := r.Context().Value(baggageKey).(Baggage)
+	return baggage
+}
+
+// BaggageFromContext is the value of the named baggage header, for handlers
+// to pass on or act on, "" when it isn't one or the request had none
+func BaggageFromContext(r *http.Request, name string) string {
+	return requestBaggage(r).Get(name)
+}
+
+// BaggageMiddleware copies the headers, like X-Tenant-ID, onto the context
+// for handlers and the extended and structured access logs. Those in
+// redact, or with names that look like secrets as in /debug/config, are
+// still passed to handlers but logged as [redacted].
+func BaggageMiddleware(headers, redact []string) mux.MiddlewareFunc {
+	sensitive := map[string]bool{}
+	for _, name := range redact {
+		sensitive[http.CanonicalHeaderKey(name)] = true
+	}
+	names := make([]string, len(headers))
+	for i, name := range headers {
+		names[i] = http.CanonicalHeaderKey(name)
+	}
+
+	return func(next http.Handler) http.Handler {
+		if len(names) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var baggage Baggage
+			for _, name := range names {
+				if value := r.Header.Get(name); value != "" {
+					baggage = append(baggage, BaggageItem{
+						Name:      name,
+						Value:     value,
+						Sensitive: sensitive[name] || sensitiveKey.MatchString(name),
+					})
+				}
+			}
+			if len(baggage) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), baggageKey, baggage)))
+		})
+	}
+}
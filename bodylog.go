@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+var (
+	redactJSON *regexp.Regexp = regexp.MustCompile(
+		`(?i)("[^"]*(?:password|passwd|secret|token|api_?key|authorization)[^"]*"\s*:\s*)"(?:[^"\\]|\\.)*(?:"|$)`,
+	)
+	redactForm *regexp.Regexp = regexp.MustCompile(
+		`(?i)((?:^|&)[^=&]*(?:password|passwd|secret|token|api_?key|authorization)[^=&]*=)[^&]*`,
+	)
+)
+
+func RedactBody(body []byte) []byte {
+	body = redactJSON.ReplaceAll(body, []byte(`$1"[REDACTED]"`))
+	return redactForm.ReplaceAll(body, []byte(`$1[REDACTED]`))
+}
+
+func isUploadContentType(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return strings.HasPrefix(mediaType, "multipart/") ||
+		mediaType == "application/octet-stream"
+}
+
+// BodyLogMiddleware is a debugging aid that logs the start of request bodies,
+// redacted and capped at limit bytes, for paths under one of prefixes. File
+// uploads are never logged.
+func BodyLogMiddleware(limit int, prefixes ...string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			matched := false
+			for _, prefix := range prefixes {
+				matched = matched || strings.HasPrefix(r.URL.Path, prefix)
+			}
+			if !matched || r.Body == nil || r.Body == http.NoBody ||
+				isUploadContentType(r.Header.Get("Content-Type")) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			captured, err := io.ReadAll(io.LimitReader(r.Body, int64(limit)+1))
+			truncated := ""
+			if len(captured) > limit {
+				captured, truncated = captured[:limit], ", truncated"
+			}
+			if err != nil {
+				truncated += ", " + err.Error()
+			}
+
+			// Hand the handler the captured bytes followed by the unread rest
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(captured), r.Body), r.Body}
+
+			LoggerFromContext(r).Printf(
+				"body (%d bytes%s): %q",
+				len(captured), truncated, RedactBody(captured),
+			)
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
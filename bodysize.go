@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// countingBody counts the bytes read through it. Handlers may still be
+// reading from their own goroutine when the count is logged, see
+// WatchdogMiddleware.
+type countingBody struct {
+	io.ReadCloser
+	n atomic.Int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n.Add(int64(n))
+	return n, err
+}
+
+// RequestBytesRead is how much of the body the handler has read so far, and
+// false when the body isn't counted
+func RequestBytesRead(r *http.Request) (int64, bool) {
+	body, ok := r.Context().Value(bodySizeKey).(*countingBody)
+	if !ok {
+		return 0, false
+	}
+	return body.n.Load(), true
+}
+
+// RequestBodySizeMiddleware counts the request body bytes actually read, for
+// the access log. That's the decoded size of chunked uploads, and 0 when the
+// handler doesn't read the body at all.
+func RequestBodySizeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := &countingBody{ReadCloser: r.Body}
+		r = r.WithContext(context.WithValue(r.Context(), bodySizeKey, body))
+		r.Body = body
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// CancelProbeMiddleware is a debugging aid finding handlers that ignore
+// their context. It cancels every request's context after delay, as if the
+// client went away, and logs how long the handler took to return afterwards,
+// flagging it when that's more than grace. Requests answered before the delay
+// aren't probed. A delay of 0 disables it.
+func CancelProbeMiddleware(delay, grace time.Duration) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		if delay <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithCancel(r.Context())
+			defer cancel()
+
+			cancelled := make(chan time.Time, 1)
+			timer := time.AfterFunc(delay, func() {
+				cancelled <- time.Now()
+				cancel()
+			})
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+
+			if timer.Stop() {
+				return
+			}
+			took := time.Since(<-cancelled)
+			if took > grace {
+				LoggerFromContext(r).Printf(
+					"%scancel probe%s route %s kept running %s after its context was cancelled",
+					colors.Red, colors.Reset, RouteName(r), took,
+				)
+				return
+			}
+			LoggerFromContext(r).Printf(
+				"%scancel probe%s route %s returned %s after its context was cancelled",
+				colors.Cyan, colors.Reset, RouteName(r), took,
+			)
+		})
+	}
+}
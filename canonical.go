@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	CanonicalOff      string = "off"
+	CanonicalRewrite  string = "rewrite"
+	CanonicalRedirect string = "redirect"
+)
+
+func ValidateCanonicalMode(mode string) error {
+	switch mode {
+	case CanonicalOff, CanonicalRewrite, CanonicalRedirect:
+		return nil
+	}
+	return fmt.Errorf("config: unknown path canonicalization mode %q", mode)
+}
+
+func collapseSlashes(path string) string {
+	var b strings.Builder
+	b.Grow(len(path))
+	for i := 0; i < len(path); i++ {
+		if path[i] == '/' && i > 0 && path[i-1] == '/' {
+			continue
+		}
+		b.WriteByte(path[i])
+	}
+	return b.String()
+}
+
+// CanonicalPath collapses repeated slashes and lowercases path. With prefixes
+// only a matching prefix is lowercased, leaving the rest, like opaque IDs,
+// as is.
+func CanonicalPath(path string, prefixes []string) string {
+	path = collapseSlashes(path)
+	if len(prefixes) == 0 {
+		return strings.ToLower(path)
+	}
+	for _, prefix := range prefixes {
+		if len(path) >= len(prefix) && strings.EqualFold(path[:len(prefix)], prefix) {
+			return strings.ToLower(prefix) + path[len(prefix):]
+		}
+	}
+	return path
+}
+
+// CanonicalPathMiddleware either rewrites the request path to its canonical
+// form before routing or permanently redirects the client to it, keeping the
+// query string.
+func CanonicalPathMiddleware(mode string, prefixes ...string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		if mode == CanonicalOff {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			canonical := CanonicalPath(r.URL.Path, prefixes)
+			if canonical == r.URL.Path {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			u := *r.URL
+			u.Path, u.RawPath = canonical, ""
+
+			if mode == CanonicalRedirect {
+				http.Redirect(w, r, u.RequestURI(), http.StatusPermanentRedirect)
+				return
+			}
+
+			rewritten := *r
+			rewritten.URL = &u
+			next.ServeHTTP(w, &rewritten)
+		})
+	}
+}
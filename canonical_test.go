@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCanonicalPathMiddleware(t *testing.T) {
+	// The handler echoes the path and query it's given
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.RequestURI()))
+	})
+
+	for _, tt := range []struct {
+		name     string
+		mode     string
+		prefixes []string
+		target   string
+		code     int
+		want     string
+	}{
+		{"off", CanonicalOff, nil, "/Items//ABC?q=X", http.StatusOK, "/Items//ABC?q=X"},
+		{"rewrite", CanonicalRewrite, nil, "/Items//ABC?q=X", http.StatusOK, "/items/abc?q=X"},
+		{"rewrite canonical", CanonicalRewrite, nil, "/items/abc", http.StatusOK, "/items/abc"},
+		{"redirect", CanonicalRedirect, nil, "/Items//ABC?q=X", http.StatusPermanentRedirect, "/items/abc?q=X"},
+		{"prefix only", CanonicalRewrite, []string{"/items/"}, "//ITEMS/AbC?q=X", http.StatusOK, "/items/AbC?q=X"},
+		{"other prefix", CanonicalRewrite, []string{"/items/"}, "/Users//AbC", http.StatusOK, "/Users/AbC"},
+		{"prefix only redirect", CanonicalRedirect, []string{"/items/"}, "/ITEMS/AbC?q=X", http.StatusPermanentRedirect, "/items/AbC?q=X"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			CanonicalPathMiddleware(tt.mode, tt.prefixes...)(echo).ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if w.Code != tt.code {
+				t.Fatalf("got %d, want %d", w.Code, tt.code)
+			}
+			got := w.Body.String()
+			if tt.code == http.StatusPermanentRedirect {
+				got = w.Header().Get("Location")
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanonicalPathOffPassesThrough(t *testing.T) {
+	next := http.RedirectHandler("/", http.StatusFound)
+	if h := CanonicalPathMiddleware(CanonicalOff)(next); h != next {
+		t.Error("off mode wraps the handler")
+	}
+}
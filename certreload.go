@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// Names of the certificate and key in TLS_CERT_DIR, as in Kubernetes TLS
+// secrets and cert-manager's
+const (
+	CertDirCert string = "tls.crt"
+	CertDirKey  string = "tls.key"
+)
+
+// TLSFiles are the certificate and key served, from TLS_CERT_DIR when set,
+// both empty when serving plain HTTP
+func (cfg *Config) TLSFiles() (cert, key string) {
+	if cfg.TLSCertDir != "" {
+		return filepath.Join(cfg.TLSCertDir, CertDirCert), filepath.Join(cfg.TLSCertDir, CertDirKey)
+	}
+	return cfg.TLSCertFile, cfg.TLSKeyFile
+}
+
+// fileStamp tells a file apart from its previous versions
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func stampFile(path string) (fileStamp, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{info.ModTime(), info.Size()}, nil
+}
+
+// CertReloader serves a certificate through tls.Config.GetCertificate and
+// swaps it for the renewed one once its files change, without dropping
+// connections. Handshakes in progress keep the one they started with.
+type CertReloader struct {
+	certFile, keyFile string
+
+	cert   atomic.Pointer[tls.Certificate]
+	stamps [2]fileStamp
+	// failed are the files last failing to load, reported once
+	failed [2]fileStamp
+}
+
+// LoadCertReloader loads the certificate of certFile and keyFile, failing
+// when they don't hold a valid pair
+func LoadCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	cr := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := cr.reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+func (cr *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return cr.cert.Load(), nil
+}
+
+// reload loads the pair again when either file changed since the last load,
+// reporting whether it did
+func (cr *CertReloader) reload() (bool, error) {
+	var stamps [2]fileStamp
+	for i, path := range []string{cr.certFile, cr.keyFile} {
+		stamp, err := stampFile(path)
+		if err != nil {
+			return false, fmt.Errorf("tls: %w", err)
+		}
+		stamps[i] = stamp
+	}
+	if stamps == cr.stamps || stamps == cr.failed {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err == nil && cert.Leaf == nil {
+		cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	}
+	if err != nil {
+		// A pair caught halfway through being renewed is tried again once
+		// the other file changes too
+		cr.failed = stamps
+		return false, err
+	}
+
+	cr.stamps = stamps
+	cr.cert.Store(&cert)
+	return true, nil
+}
+
+// Expiry is when the certificate being served expires
+func (cr *CertReloader) Expiry() time.Time {
+	return cr.cert.Load().Leaf.NotAfter
+}
+
+// Watch checks the files every interval until ctx is done, logging each
+// reload. A pair failing to load is logged and the current certificate
+// kept.
+func (cr *CertReloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := cr.reload()
+			if err != nil {
+				log.Println("| TLS certificate reload failed, keeping the current one: " + err.Error())
+				continue
+			}
+			if reloaded {
+				log.Println("| Reloaded TLS certificate, expiring " + cr.Expiry().Format(time.RFC3339))
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Chaos delays requests to some routes by a random time between MinLatency
+// and MaxLatency, and fails ErrorPercent of them with a 500, to check how
+// clients cope. A nil *Chaos does nothing.
+type Chaos struct {
+	Routes       map[string]bool
+	MinLatency   time.Duration
+	MaxLatency   time.Duration
+	ErrorPercent int
+}
+
+// NewChaos returns nil, no chaos, unless debugging with routes to apply it to
+func NewChaos(debug bool, routes []string, minLatency, maxLatency time.Duration, errorPercent int) *Chaos {
+	if !debug || len(routes) == 0 {
+		return nil
+	}
+	c := &Chaos{
+		Routes:       map[string]bool{},
+		MinLatency:   minLatency,
+		MaxLatency:   maxLatency,
+		ErrorPercent: errorPercent,
+	}
+	for _, route := range routes {
+		c.Routes[route] = true
+	}
+	return c
+}
+
+func (c *Chaos) latency() time.Duration {
+	if c.MaxLatency <= c.MinLatency {
+		return c.MinLatency
+	}
+	return c.MinLatency + time.Duration(rand.Int63n(int64(c.MaxLatency-c.MinLatency)+1))
+}
+
+// ChaosMiddleware applies c to the routes it names, logging every delay and
+// failure it causes so test results can be told apart from real ones
+func ChaosMiddleware(c *Chaos) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		if c == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !c.Routes[RouteName(r)] {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if delay := c.latency(); delay > 0 {
+				LoggerFromContext(r).Printf("%schaos%s delaying by %s", colors.Magenta, colors.Reset, delay)
+				select {
+				case <-time.After(delay):
+				case <-r.Context().Done():
+					return
+				}
+			}
+
+			if c.ErrorPercent > 0 && rand.Intn(100) < c.ErrorPercent {
+				LoggerFromContext(r).Printf("%schaos%s failing with a 500", colors.Magenta, colors.Reset)
+				http.Error(
+					w,
+					http.StatusText(http.StatusInternalServerError),
+					http.StatusInternalServerError,
+				)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const clfTimeLayout string = "02/Jan/2006:15:04:05 -0700"
+
+// basicAuthUser is the user a request authenticates as, "" when none
+func basicAuthUser(r *http.Request) string {
+	user, _, _ := r.BasicAuth()
+	return user
+}
+
+func clfField(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// CommonString lays the entry out in the Common Log Format,
+// host ident authuser [date] "request" status bytes, each missing field
+// being a "-" as the format has it. The line carries its own date, Logger
+// adds none.
+func (rl RequestLogger) CommonString() string {
+	start := rl.GetStart()
+	if start.IsZero() {
+		start = time.Now()
+	}
+
+	request := "-"
+	if rl.GetMethod() != "" {
+		uri := rl.GetRequestURI()
+		if uri == "" {
+			uri = rl.GetPath()
+		}
+		request = strings.ReplaceAll(sanitize(rl.GetMethod()+" "+uri+" "+rl.GetProto()), `"`, `\"`)
+	}
+
+	bytes := "-"
+	if rl.GetResponseBytes() > 0 {
+		bytes = strconv.FormatInt(rl.GetResponseBytes(), 10)
+	}
+
+	return strings.Join([]string{
+		clfField(rl.GetClientIP()),
+		"-",
+		clfField(sanitize(rl.GetUser())),
+		"[" + start.Format(clfTimeLayout) + "]",
+		`"` + strings.TrimSpace(request) + `"`,
+		strconv.Itoa(rl.GetStatus()),
+		bytes,
+	}, " ")
+}
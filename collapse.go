@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// repeats holds back access lines repeating the previous one's method,
+// status and path, like a health checker's, until they stop coming for
+// window or a different one comes, then logs how many there were
+type repeats struct {
+	l      *Logger
+	window time.Duration
+
+	mu    sync.Mutex
+	key   string
+	level LogLevel
+	last  *RequestLogger
+	count int
+	timer *time.Timer
+}
+
+func repeatKey(rl *RequestLogger) string {
+	return rl.GetMethod() + " " + strconv.Itoa(rl.GetStatus()) + " " + rl.GetPath()
+}
+
+// CollapseRepeats has l log consecutive access lines of the same method,
+// status and path once, followed by how many more there were once they
+// stop for window. A window of 0 logs each one.
+func (l *Logger) CollapseRepeats(window time.Duration) {
+	if window <= 0 {
+		l.repeats = nil
+		return
+	}
+	l.repeats = &repeats{l: l, window: window}
+}
+
+// hold reports whether rl repeats the previous line and was counted instead
+// of logged
+func (rp *repeats) hold(level LogLevel, rl *RequestLogger) bool {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	key := repeatKey(rl)
+	if key == rp.key {
+		rp.count++
+		rp.last = rl
+		rp.timer.Reset(rp.window)
+		return true
+	}
+
+	rp.flushLocked()
+	rp.key, rp.level = key, level
+	rp.timer = time.AfterFunc(rp.window, rp.Flush)
+	return false
+}
+
+// Flush logs the count of the lines held back, if any, and has the next
+// line logged whatever it repeats
+func (rp *repeats) Flush() {
+	if rp == nil {
+		return
+	}
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	rp.flushLocked()
+}
+
+func (rp *repeats) flushLocked() {
+	if rp.timer != nil {
+		rp.timer.Stop()
+	}
+	if rp.count > 0 {
+		rp.l.Print(rp.level, RepeatedLines{rp.last, rp.count})
+	}
+	rp.key, rp.last, rp.count = "", nil, 0
+}
+
+// RepeatedLines tells how many access lines like Last were held back
+type RepeatedLines struct {
+	Last  *RequestLogger
+	Count int
+}
+
+func (rl RepeatedLines) Format(format LogFormat) string {
+	if format == LogFormatJSON {
+		b, _ := json.Marshal(map[string]interface{}{
+			"repeated": rl.Count,
+			"method":   rl.Last.GetMethod(),
+			"status":   rl.Last.GetStatus(),
+			"path":     rl.Last.GetPath(),
+		})
+		return string(b)
+	}
+	if format == LogFormatLogfmt {
+		return logfmtString(
+			"repeated", rl.Count, "method", rl.Last.GetMethod(),
+			"status", rl.Last.GetStatus(), "path", rl.Last.GetPath(),
+		)
+	}
+	times := "times"
+	if rl.Count == 1 {
+		times = "time"
+	}
+	return fmt.Sprintf(
+		"%s last line repeated %d more %s %s %s %s %s",
+		logTheme.Sep(), rl.Count, times, logTheme.Sep(), rl.Last.GetMethod(),
+		GetStatusColor(rl.Last.GetStatus())+strconv.Itoa(rl.Last.GetStatus())+colors.Reset,
+		sanitize(rl.Last.GetPath()),
+	)
+}
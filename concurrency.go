@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// QueueTimeFromContext is how long the request waited for a concurrency
+// slot, and false when it didn't go through the limiter
+func QueueTimeFromContext(r *http.Request) (time.Duration, bool) {
+	waited, ok := r.Context().Value(queueTimeKey).(time.Duration)
+	return waited, ok
+}
+
+// acquireSlot takes a slot, queueing for up to timeout, or answers the 503
+// and returns false
+func acquireSlot(slots chan struct{}, timeout time.Duration, w http.ResponseWriter, r *http.Request) bool {
+	if timeout <= 0 {
+		select {
+		case slots <- struct{}{}:
+			return true
+		default:
+			SetRetryAfter(w.Header(), 0)
+			Reject(w, r, http.StatusServiceUnavailable, "no concurrency slot free")
+			return false
+		}
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		SetRetryAfter(w.Header(), timeout)
+		Reject(w, r, http.StatusServiceUnavailable, "no concurrency slot within "+timeout.String())
+	case <-r.Context().Done():
+		LogRejection(r, r.URL.Path, StatusClientClosedRequest, "gone while queued")
+	}
+	return false
+}
+
+// ConcurrencyLimitMiddleware lets at most max requests in at once, health
+// checks aside. The others queue for a slot for up to timeout, then get a
+// 503, and the time they waited is attached to the context for the access
+// log, apart from the handler's own duration. A timeout of 0 doesn't queue
+// at all, the 503 coming right away. A max of 0 disables it.
+func ConcurrencyLimitMiddleware(max int, timeout time.Duration) mux.MiddlewareFunc {
+	if max <= 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	slots := make(chan struct{}, max)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if IsHealthCheck(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			start := time.Now()
+			if !acquireSlot(slots, timeout, w, r) {
+				return
+			}
+			defer func() { <-slots }()
+
+			ctx := context.WithValue(r.Context(), queueTimeKey, time.Since(start))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// blockingHandler holds each request until release is closed, telling on
+// entered when it starts
+func blockingHandler(entered chan<- struct{}, release <-chan struct{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release
+	})
+}
+
+func TestConcurrencyLimitDisabled(t *testing.T) {
+	for _, max := range []int{0, -1} {
+		w := httptest.NewRecorder()
+		ConcurrencyLimitMiddleware(max, time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+		})).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != http.StatusAccepted {
+			t.Errorf("max %d: got %d, want the handler's 202", max, w.Code)
+		}
+	}
+}
+
+func TestConcurrencyLimitQueue(t *testing.T) {
+	captureStdLog(t)
+	captureLog(t, accessLog)
+
+	for _, tt := range []struct {
+		name    string
+		timeout time.Duration
+	}{
+		{"without queueing", 0},
+		{"past the queue timeout", 20 * time.Millisecond},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			entered, release := make(chan struct{}), make(chan struct{})
+			handler := ConcurrencyLimitMiddleware(1, tt.timeout)(blockingHandler(entered, release))
+
+			done := make(chan struct{})
+			go func() {
+				handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+				close(done)
+			}()
+			<-entered
+
+			start := time.Now()
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+				t.Errorf("got %d, Retry-After %q, want a 503 with one", w.Code, w.Header().Get("Retry-After"))
+			}
+			if waited := time.Since(start); waited < tt.timeout {
+				t.Errorf("refused after %s, under the %s timeout", waited, tt.timeout)
+			}
+			close(release)
+			<-done
+		})
+	}
+}
+
+func TestConcurrencyLimitValidated(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxConcurrentRequests = -1
+	if err := cfg.validate(); err == nil {
+		t.Error("negative max concurrent requests accepted")
+	}
+	cfg = DefaultConfig()
+	cfg.QueueTimeout = Duration(-time.Second)
+	if err := cfg.validate(); err == nil {
+		t.Error("negative queue timeout accepted")
+	}
+	cfg.QueueTimeout = 0
+	if err := cfg.validate(); err != nil {
+		t.Errorf("queue timeout of 0 refused: %v", err)
+	}
+}
@@ -0,0 +1,720 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is resolved from defaults, then an optional JSON file given with
+// --config, then environment variables, then command line flags, each one
+// overriding the previous.
+type Config struct {
+	CanonicalPaths         string              `json:"canonical_paths"`
+	CanonicalPrefixes      []string            `json:"canonical_prefixes"`
+	StatusSummaryInterval  Duration            `json:"status_summary_interval"`
+	AdminToken             string              `json:"admin_token"`
+	Maintenance            bool                `json:"maintenance"`
+	MaintenanceMessage     string              `json:"maintenance_message"`
+	MaintenanceRetryAfter  Duration            `json:"maintenance_retry_after"`
+	ReadOnly               bool                `json:"read_only"`
+	ReadOnlyMessage        string              `json:"read_only_message"`
+	ReadOnlyRetryAfter     Duration            `json:"read_only_retry_after"`
+	Metrics                bool                `json:"metrics"`
+	Addr                   string              `json:"listen_addr"`
+	SocketMode             string              `json:"socket_mode"`
+	Debug                  bool                `json:"debug"`
+	CurlLogPrefixes        []string            `json:"curl_log_prefixes"`
+	MaxHeaderCount         int                 `json:"max_header_count"`
+	MaxURLLength           int                 `json:"max_url_length"`
+	LogSeparator           string              `json:"log_separator"`
+	LogSeparatorColor      string              `json:"log_separator_color"`
+	AccessLog              string              `json:"access_log"`
+	AccessLogLevel         LogLevel            `json:"access_log_level"`
+	ErrorLog               string              `json:"error_log"`
+	ErrorLogFormat         LogFormat           `json:"error_log_format"`
+	LogBackend             string              `json:"log_backend"`
+	ErrorLogLevel          LogLevel            `json:"error_log_level"`
+	BodyLogLimit           int                 `json:"body_log_limit"`
+	BodyLogPrefixes        []string            `json:"body_log_prefixes"`
+	HandlerTimeout         Duration            `json:"handler_timeout"`
+	RouteTimeouts          map[string]Duration `json:"route_timeouts"`
+	LogExclusions          LogExclusions       `json:"log_exclusions"`
+	LogFormat              LogFormat           `json:"log_format"`
+	MaxPathLength          int                 `json:"max_path_length"`
+	MinTLSVersion          string              `json:"tls_min_version"`
+	PathRules              []string            `json:"path_rules"`
+	ServedBy               string              `json:"served_by"`
+	TLSCertFile            string              `json:"tls_cert_file"`
+	TLSKeyFile             string              `json:"tls_key_file"`
+	TLSCertDir             string              `json:"tls_cert_dir"`
+	TLSReloadInterval      Duration            `json:"tls_reload_interval"`
+	SlowRenderThreshold    Duration            `json:"slow_render_threshold"`
+	RenderBufferSize       int                 `json:"render_buffer_size"`
+	GeoIPDatabase          string              `json:"geoip_database"`
+	MaxHeapInuse           int                 `json:"max_heap_inuse"`
+	GzipLevel              int                 `json:"gzip_level"`
+	AllowedHosts           []string            `json:"allowed_hosts"`
+	WriteTimeout           Duration            `json:"write_timeout"`
+	WriteDeadlineMargin    Duration            `json:"write_deadline_margin"`
+	RuntimeStatsInterval   Duration            `json:"runtime_stats_interval"`
+	RuntimeVars            bool                `json:"runtime_vars"`
+	MinRoutes              int                 `json:"min_routes"`
+	StrictRoutes           bool                `json:"strict_routes"`
+	CSRF                   bool                `json:"csrf"`
+	CSRFCookie             string              `json:"csrf_cookie"`
+	CSRFSecret             string              `json:"csrf_secret"`
+	APIVendor              string              `json:"api_vendor"`
+	APIVersions            []string            `json:"api_versions"`
+	APIDefaultVersion      int                 `json:"api_default_version"`
+	DryRun                 bool                `json:"dry_run"`
+	ShutdownReadinessDelay Duration            `json:"shutdown_readiness_delay"`
+	ShutdownDrainTimeout   Duration            `json:"shutdown_drain_timeout"`
+	ShutdownHookTimeout    Duration            `json:"shutdown_hook_timeout"`
+	BlockedMethods         []string            `json:"blocked_methods"`
+	RetryAfterJitter       Duration            `json:"retry_after_jitter"`
+	LogTimePrecision       string              `json:"log_time_precision"`
+	RecentRequests         int                 `json:"recent_requests"`
+	RequestIDTTL           Duration            `json:"request_id_ttl"`
+	RequestIDCacheSize     int                 `json:"request_id_cache_size"`
+	Recover                bool                `json:"recover"`
+	Favicon                string              `json:"favicon"`
+	FaviconPath            string              `json:"favicon_path"`
+	MaxBodySize            int                 `json:"max_body_size"`
+	CancelProbe            Duration            `json:"cancel_probe"`
+	CancelProbeGrace       Duration            `json:"cancel_probe_grace"`
+	SafeMethodCheck        bool                `json:"safe_method_check"`
+	SafeMethodHeaders      []string            `json:"safe_method_headers"`
+	StatusRewrites         StatusRewrites      `json:"status_rewrites"`
+	StatusRewriteMaxBody   int                 `json:"status_rewrite_max_body"`
+	RateLimit              RateLimit           `json:"rate_limit"`
+	RouteRateLimits        RateLimits          `json:"route_rate_limits"`
+	RateLimitBy            string              `json:"rate_limit_by"`
+	ErrorPenaltyThreshold  int                 `json:"error_penalty_threshold"`
+	ErrorPenaltyDecay      Duration            `json:"error_penalty_decay"`
+	ErrorPenaltyRateLimit  RateLimit           `json:"error_penalty_rate_limit"`
+	SlowBodyThreshold      Duration            `json:"slow_body_threshold"`
+	MethodOverride         []string            `json:"method_override"`
+	LogControlChars        string              `json:"log_control_chars"`
+	PanicStack             bool                `json:"panic_stack"`
+	ContentTypeCheck       bool                `json:"content_type_check"`
+	DefaultContentType     string              `json:"default_content_type"`
+	BaggageHeaders         []string            `json:"baggage_headers"`
+	BaggageRedact          []string            `json:"baggage_redact"`
+	MaxConcurrentRequests  int                 `json:"max_concurrent_requests"`
+	QueueTimeout           Duration            `json:"queue_timeout"`
+	ChaosRoutes            []string            `json:"chaos_routes"`
+	ChaosMinLatency        Duration            `json:"chaos_min_latency"`
+	ChaosMaxLatency        Duration            `json:"chaos_max_latency"`
+	ChaosErrorPercent      int                 `json:"chaos_error_percent"`
+	RouteHeaders           RouteHeaders        `json:"route_headers"`
+	SingleFlightRoutes     []string            `json:"single_flight_routes"`
+	SingleFlightMaxBody    int                 `json:"single_flight_max_body"`
+	AsyncLogBuffer         int                 `json:"async_log_buffer"`
+	HMACRoutes             []string            `json:"hmac_routes"`
+	HMACSecret             string              `json:"hmac_secret"`
+	HMACHeader             string              `json:"hmac_header"`
+	HMACEncoding           string              `json:"hmac_encoding"`
+	DebugConfig            bool                `json:"debug_config"`
+	ErrorRatePercent       int                 `json:"error_rate_percent"`
+	ErrorRateWindow        Duration            `json:"error_rate_window"`
+	ErrorRateMinRequests   int                 `json:"error_rate_min_requests"`
+	SequenceTTL            Duration            `json:"sequence_ttl"`
+	SequenceSessions       int                 `json:"sequence_sessions"`
+	SequenceSessionHeader  string              `json:"sequence_session_header"`
+	SequenceTolerance      int                 `json:"sequence_tolerance"`
+	LogCollapseWindow      Duration            `json:"log_collapse_window"`
+	MaxConnections         int                 `json:"max_connections"`
+	MiddlewareTiming       Duration            `json:"middleware_timing"`
+	SizeBuckets            []int               `json:"size_buckets"`
+	RequestBudget          int                 `json:"request_budget"`
+	BusyPage               string              `json:"busy_page"`
+}
+
+func DefaultConfig() *Config {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "-"
+	}
+
+	return &Config{
+		CanonicalPaths:        CanonicalOff,
+		CanonicalPrefixes:     []string{},
+		MaintenanceMessage:    "We're down for maintenance and will be back shortly.",
+		MaintenanceRetryAfter: Duration(2 * time.Minute),
+		ReadOnlyMessage:       "We're in read-only mode for maintenance, changes can't be made right now.",
+		ReadOnlyRetryAfter:    Duration(2 * time.Minute),
+		Addr:                  "127.0.0.1:8000",
+		SocketMode:            "0660",
+		CurlLogPrefixes:       []string{},
+		MaxHeaderCount:        100,
+		MaxURLLength:          8192,
+		LogSeparator:          "|",
+		AccessLog:             "stderr",
+		AccessLogLevel:        LevelInfo,
+		ErrorLog:              "stderr",
+		ErrorLogFormat:        LogFormatDefault,
+		LogBackend:            LogBackendLog,
+		ErrorLogLevel:         LevelInfo,
+		BodyLogLimit:          4096,
+		BodyLogPrefixes:       []string{},
+		HandlerTimeout:        Duration(10 * time.Second),
+		RouteTimeouts:         map[string]Duration{"events": 0},
+		LogExclusions:         LogExclusions{Prefixes: []string{}, LogErrors: true},
+		LogFormat:             LogFormatDefault,
+		MaxPathLength:         2048,
+		MinTLSVersion:         "1.2",
+		TLSReloadInterval:     Duration(time.Minute),
+		PathRules:             []string{"traversal", "control", "length"},
+		ServedBy:              hostname,
+		AllowedHosts:          []string{},
+		WriteTimeout:          Duration(15 * time.Second),
+		WriteDeadlineMargin:   Duration(time.Second),
+		MinRoutes:             1,
+		CSRFCookie:            "csrf_token",
+		ShutdownDrainTimeout:  Duration(15 * time.Second),
+		ShutdownHookTimeout:   Duration(5 * time.Second),
+		BlockedMethods:        []string{"TRACE", "CONNECT", "TRACK"},
+		APIVendor:             "reststd",
+		LogTimePrecision:      "s",
+		RecentRequests:        100,
+		RequestIDCacheSize:    10000,
+		Recover:               true,
+		FaviconPath:           "/favicon.ico",
+		MaxBodySize:           10 << 20,
+		SizeBuckets:           []int{256, 1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20},
+		CancelProbeGrace:      Duration(100 * time.Millisecond),
+		StatusRewrites:        StatusRewrites{},
+		StatusRewriteMaxBody:  64 << 10,
+		RouteRateLimits:       RateLimits{},
+		RateLimitBy:           "ip",
+		ErrorPenaltyDecay:     Duration(time.Minute),
+		ErrorPenaltyRateLimit: RateLimit{Rate: 1, Burst: 1},
+		MethodOverride:        []string{},
+		LogControlChars:       ControlCharsEscape,
+		QueueTimeout:          Duration(5 * time.Second),
+		ChaosRoutes:           []string{},
+		RouteHeaders:          RouteHeaders{},
+		SingleFlightRoutes:    []string{},
+		SingleFlightMaxBody:   1 << 20,
+		HMACRoutes:            []string{},
+		HMACHeader:            "X-Signature-256",
+		HMACEncoding:          HMACHex,
+		ErrorRateWindow:       Duration(time.Minute),
+		ErrorRateMinRequests:  20,
+		SequenceSessions:      10000,
+		SequenceSessionHeader: "X-Session-ID",
+		APIVersions:           []string{},
+		SlowRenderThreshold:   Duration(100 * time.Millisecond),
+		RenderBufferSize:      1 << 20,
+	}
+}
+
+type configVar struct {
+	env   string
+	flag  string
+	usage string
+	value flag.Value
+}
+
+func (cfg *Config) vars() []configVar {
+	return []configVar{
+		{"CANONICAL_PATHS", "canonical-paths", "lowercase paths and collapse slashes: off, rewrite or redirect", (*stringValue)(&cfg.CanonicalPaths)},
+		{"CANONICAL_PREFIXES", "canonical-prefixes", "only lowercase these path prefixes, the whole path when empty", (*listValue)(&cfg.CanonicalPrefixes)},
+		{"STATUS_SUMMARY_INTERVAL", "status-summary-interval", "how often to log response counts per status class, 0 disables", &cfg.StatusSummaryInterval},
+		{"ADMIN_TOKEN", "admin-token", "bearer token of the /admin endpoints, disabled when empty", (*stringValue)(&cfg.AdminToken)},
+		{"MAINTENANCE", "maintenance", "start in maintenance mode, toggled by SIGUSR2 or /admin/maintenance", (*boolValue)(&cfg.Maintenance)},
+		{"MAINTENANCE_MESSAGE", "maintenance-message", "body of maintenance mode responses", (*stringValue)(&cfg.MaintenanceMessage)},
+		{"MAINTENANCE_RETRY_AFTER", "maintenance-retry-after", "Retry-After of maintenance mode responses", &cfg.MaintenanceRetryAfter},
+		{"READ_ONLY", "read-only", "start in read-only mode, refusing POST, PUT, PATCH and DELETE, toggled by SIGUSR1 or /admin/read_only", (*boolValue)(&cfg.ReadOnly)},
+		{"READ_ONLY_MESSAGE", "read-only-message", "body of read-only mode responses", (*stringValue)(&cfg.ReadOnlyMessage)},
+		{"READ_ONLY_RETRY_AFTER", "read-only-retry-after", "Retry-After of read-only mode responses", &cfg.ReadOnlyRetryAfter},
+		{"METRICS", "metrics", "expose Prometheus metrics on /metrics", (*boolValue)(&cfg.Metrics)},
+		{"LISTEN_ADDR", "listen-addr", "host:port, a free port with port 0, or unix:/path/to/sock, to listen on", (*stringValue)(&cfg.Addr)},
+		{"PORT", "port", "port of LISTEN_ADDR, as platforms assigning one set it, overriding LISTEN_ADDR's", (*portValue)(&cfg.Addr)},
+		{"SOCKET_MODE", "socket-mode", "octal permissions of the unix socket file", (*stringValue)(&cfg.SocketMode)},
+		{"DEBUG", "debug", "enable debugging aids, never in production", (*boolValue)(&cfg.Debug)},
+		{"CURL_LOG_PREFIXES", "curl-log-prefixes", "debug: log requests under these path prefixes as curl commands", (*listValue)(&cfg.CurlLogPrefixes)},
+		{"LOG_SEPARATOR", "log-separator", "column separator of the text log formats", (*stringValue)(&cfg.LogSeparator)},
+		{"LOG_SEPARATOR_COLOR", "log-separator-color", "separator color: none, dim, red, green, yellow, blue, magenta, cyan", (*stringValue)(&cfg.LogSeparatorColor)},
+		{"ACCESS_LOG", "access-log", "access log destination: stderr, stdout or a file", (*stringValue)(&cfg.AccessLog)},
+		{"ACCESS_LOG_LEVEL", "access-log-level", "lowest level logged: debug, info (2xx/3xx), warn (4xx), error (5xx)", &cfg.AccessLogLevel},
+		{"ERROR_LOG", "error-log", "error log destination: stderr, stdout or a file", (*stringValue)(&cfg.ErrorLog)},
+		{"ERROR_LOG_FORMAT", "error-log-format", "error log format: default, extended, json or logfmt", &cfg.ErrorLogFormat},
+		{"LOG_BACKEND", "log-backend", "log through the log package in the log formats, or as slog JSON records to the same destinations", (*stringValue)(&cfg.LogBackend)},
+		{"ERROR_LOG_LEVEL", "error-log-level", "lowest error log level: debug, info, warn, error", &cfg.ErrorLogLevel},
+		{"BODY_LOG_LIMIT", "body-log-limit", "most request body bytes logged", (*intValue)(&cfg.BodyLogLimit)},
+		{"BODY_LOG_PREFIXES", "body-log-prefixes", "debug: log request bodies under these path prefixes", (*listValue)(&cfg.BodyLogPrefixes)},
+		{"HANDLER_TIMEOUT", "handler-timeout", "longest a handler may run, 0 disables", &cfg.HandlerTimeout},
+		{"ROUTE_TIMEOUTS", "route-timeouts", "per route handler timeouts, as name=duration,...", (*durationMapValue)(&cfg.RouteTimeouts)},
+		{"LOG_EXCLUDE", "log-exclude", "path prefixes left out of the access log", (*listValue)(&cfg.LogExclusions.Prefixes)},
+		{"LOG_EXCLUDE_ERRORS", "log-exclude-errors", "still log non 2xx responses of excluded paths", (*boolValue)(&cfg.LogExclusions.LogErrors)},
+		{"LOG_FORMAT", "log-format", "access log format: default, extended, json, logfmt or common", &cfg.LogFormat},
+		{"MAX_HEADER_COUNT", "max-header-count", "most header fields accepted, 0 disables", (*intValue)(&cfg.MaxHeaderCount)},
+		{"MAX_URL_LENGTH", "max-url-length", "longest accepted URL, 0 disables", (*intValue)(&cfg.MaxURLLength)},
+		{"MAX_PATH_LENGTH", "max-path-length", "longest accepted request path", (*intValue)(&cfg.MaxPathLength)},
+		{"TLS_MIN_VERSION", "tls-min-version", "lowest accepted TLS version", (*stringValue)(&cfg.MinTLSVersion)},
+		{"PATH_RULES", "path-rules", "request path rules: traversal, control, length", (*listValue)(&cfg.PathRules)},
+		{"SERVED_BY", "served-by", "X-Served-By header value, the hostname by default", (*stringValue)(&cfg.ServedBy)},
+		{"TLS_CERT_FILE", "tls-cert-file", "TLS certificate, serves plain HTTP when empty", (*stringValue)(&cfg.TLSCertFile)},
+		{"TLS_KEY_FILE", "tls-key-file", "TLS private key", (*stringValue)(&cfg.TLSKeyFile)},
+		{"TLS_CERT_DIR", "tls-cert-dir", "directory holding the TLS certificate as tls.crt and key as tls.key, instead of TLS_CERT_FILE", (*stringValue)(&cfg.TLSCertDir)},
+		{"TLS_RELOAD_INTERVAL", "tls-reload-interval", "how often to check the TLS certificate files for a renewed one, 0 disables", &cfg.TLSReloadInterval},
+		{"SLOW_RENDER_THRESHOLD", "slow-render-threshold", "template renders slower than this are logged", &cfg.SlowRenderThreshold},
+		{"RENDER_BUFFER_SIZE", "render-buffer-size", "bytes of a rendered page held back to answer a clean 500 should the template fail, larger ones streamed", (*intValue)(&cfg.RenderBufferSize)},
+		{"GEOIP_DATABASE", "geoip-database", "MaxMind GeoIP2 Country or City database logging client locations", (*stringValue)(&cfg.GeoIPDatabase)},
+		{"MAX_HEAP_INUSE", "max-heap-inuse", "bytes of heap in use over which requests are shed with a 503, 0 disables", (*intValue)(&cfg.MaxHeapInuse)},
+		{"GZIP_LEVEL", "gzip-level", "gzip level of responses, 1 (fastest) to 9 (smallest), 0 disables", (*intValue)(&cfg.GzipLevel)},
+		{"ALLOWED_HOSTS", "allowed-hosts", "Host headers served, like example.com or *.example.com, any when empty", (*listValue)(&cfg.AllowedHosts)},
+		{"WRITE_TIMEOUT", "write-timeout", "longest the server spends writing a response, 0 disables", &cfg.WriteTimeout},
+		{"WRITE_DEADLINE_MARGIN", "write-deadline-margin", "how long before the write timeout handlers' contexts are done", &cfg.WriteDeadlineMargin},
+		{"RUNTIME_STATS_INTERVAL", "runtime-stats-interval", "how often to log the goroutine count and memory stats, 0 disables", &cfg.RuntimeStatsInterval},
+		{"RUNTIME_VARS", "runtime-vars", "expose the goroutine count and memory stats on /debug/vars", (*boolValue)(&cfg.RuntimeVars)},
+		{"MIN_ROUTES", "min-routes", "fewest application routes expected at startup, warning otherwise", (*intValue)(&cfg.MinRoutes)},
+		{"STRICT_ROUTES", "strict-routes", "refuse to start with fewer than MIN_ROUTES routes", (*boolValue)(&cfg.StrictRoutes)},
+		{"CSRF", "csrf", "require a CSRF token on POST, PUT, PATCH and DELETE requests", (*boolValue)(&cfg.CSRF)},
+		{"CSRF_COOKIE", "csrf-cookie", "name of the CSRF token cookie", (*stringValue)(&cfg.CSRFCookie)},
+		{"CSRF_SECRET", "csrf-secret", "key signing CSRF tokens, random per start when empty", (*stringValue)(&cfg.CSRFSecret)},
+		{"API_VENDOR", "api-vendor", "vendor of the application/vnd.<vendor>.v<N>+json API media types", (*stringValue)(&cfg.APIVendor)},
+		{"API_VERSIONS", "api-versions", "API versions served, negotiated from Accept, disabled when empty", (*listValue)(&cfg.APIVersions)},
+		{"API_DEFAULT_VERSION", "api-default-version", "API version when Accept names none, the latest when 0", (*intValue)(&cfg.APIDefaultVersion)},
+		{"DRY_RUN", "dry-run", "log the route requests match and answer 204 without running handlers", (*boolValue)(&cfg.DryRun)},
+		{"SHUTDOWN_READINESS_DELAY", "shutdown-readiness-delay", "how long to keep serving once /readyz fails on shutdown", &cfg.ShutdownReadinessDelay},
+		{"SHUTDOWN_DRAIN_TIMEOUT", "shutdown-drain-timeout", "longest to wait for in flight requests on shutdown", &cfg.ShutdownDrainTimeout},
+		{"SHUTDOWN_HOOK_TIMEOUT", "shutdown-hook-timeout", "longest each shutdown hook may take", &cfg.ShutdownHookTimeout},
+		{"BLOCKED_METHODS", "blocked-methods", "methods refused with a 405 before routing", (*listValue)(&cfg.BlockedMethods)},
+		{"RETRY_AFTER_JITTER", "retry-after-jitter", "random extra delay spread over the Retry-After of 503s", &cfg.RetryAfterJitter},
+		{"LOG_TIME_PRECISION", "log-time-precision", "precision of access and error log timestamps: s, ms or us", (*stringValue)(&cfg.LogTimePrecision)},
+		{"RECENT_REQUESTS", "recent-requests", "debug: how many recent requests /debug/requests keeps", (*intValue)(&cfg.RecentRequests)},
+		{"REQUEST_ID_TTL", "request-id-ttl", "refuse incoming X-Request-IDs seen this recently with a 409, 0 disables", &cfg.RequestIDTTL},
+		{"REQUEST_ID_CACHE_SIZE", "request-id-cache-size", "most request IDs remembered to catch duplicates", (*intValue)(&cfg.RequestIDCacheSize)},
+		{"RECOVER", "recover", "recover handler panics as 500s, crash on them when false to debug them", (*boolValue)(&cfg.Recover)},
+		{"FAVICON", "favicon", "icon file served on the favicon path, a 204 answers it when empty", (*stringValue)(&cfg.Favicon)},
+		{"FAVICON_PATH", "favicon-path", "path the favicon is served on, left out of the access log", (*stringValue)(&cfg.FaviconPath)},
+		{"MAX_BODY_SIZE", "max-body-size", "largest request body in bytes, declared or read, 0 disables", (*intValue)(&cfg.MaxBodySize)},
+		{"CANCEL_PROBE", "cancel-probe", "debug: cancel request contexts after this long to find handlers ignoring them, 0 disables", &cfg.CancelProbe},
+		{"CANCEL_PROBE_GRACE", "cancel-probe-grace", "debug: how soon handlers should return once cancelled by the probe", &cfg.CancelProbeGrace},
+		{"SAFE_METHOD_CHECK", "safe-method-check", "debug: warn about GET and HEAD answered with a 2xx looking like a state change, a 201 or cookies set", (*boolValue)(&cfg.SafeMethodCheck)},
+		{"SAFE_METHOD_HEADERS", "safe-method-headers", "debug: comma separated response headers, like X-Created-ID, also counted as a state change by SAFE_METHOD_CHECK", (*listValue)(&cfg.SafeMethodHeaders)},
+		{"STATUS_REWRITES", "status-rewrites", `per route status overrides for matching bodies, as JSON like {"name": {"pattern": "error", "status": 502}}`, &cfg.StatusRewrites},
+		{"STATUS_REWRITE_MAX_BODY", "status-rewrite-max-body", "largest response in bytes buffered for status rewrites, larger ones go out unchanged", (*intValue)(&cfg.StatusRewriteMaxBody)},
+		{"RATE_LIMIT", "rate-limit", "requests per client IP, like 10/s, 600/m:20 with a burst of 20, or unlimited", &cfg.RateLimit},
+		{"ROUTE_RATE_LIMITS", "route-rate-limits", "per route rate limits replacing the global one, as name=rate,... where a rate may be unlimited", &cfg.RouteRateLimits},
+		{"RATE_LIMIT_BY", "rate-limit-by", "count rate limits per ip, or per authenticated user falling back to the ip once an authentication middleware sets it", (*stringValue)(&cfg.RateLimitBy)},
+		{"ERROR_PENALTY_THRESHOLD", "error-penalty-threshold", "client errors (4xx but 429) of an IP, decaying over ERROR_PENALTY_DECAY, past which it's held to ERROR_PENALTY_RATE_LIMIT, 0 disables", (*intValue)(&cfg.ErrorPenaltyThreshold)},
+		{"ERROR_PENALTY_DECAY", "error-penalty-decay", "time for a client's error score to lose two thirds of itself, the penalty lifting under half the threshold", &cfg.ErrorPenaltyDecay},
+		{"ERROR_PENALTY_RATE_LIMIT", "error-penalty-rate-limit", "rate limit of penalized clients, like 1/s or 30/m:5", &cfg.ErrorPenaltyRateLimit},
+		{"SLOW_BODY_THRESHOLD", "slow-body-threshold", "warn when reading a request body waits on the client longer, 0 disables", &cfg.SlowBodyThreshold},
+		{"METHOD_OVERRIDE", "method-override", "methods POST requests may be routed as with X-HTTP-Method-Override, like PUT,PATCH,DELETE, none when empty", (*listValue)(&cfg.MethodOverride)},
+		{"LOG_CONTROL_CHARS", "log-control-chars", "control characters in logged values, like ANSI escapes in paths: escape or strip", (*stringValue)(&cfg.LogControlChars)},
+		{"PANIC_STACK", "panic-stack", "log the panicking goroutine's stack below recovered panics", (*boolValue)(&cfg.PanicStack)},
+		{"CONTENT_TYPE_CHECK", "content-type-check", "warn about responses with a body but no Content-Type, left to sniffing, to catch handlers forgetting it", (*boolValue)(&cfg.ContentTypeCheck)},
+		{"DEFAULT_CONTENT_TYPE", "default-content-type", "Content-Type sent instead of sniffing with CONTENT_TYPE_CHECK, empty to keep sniffing", (*stringValue)(&cfg.DefaultContentType)},
+		{"BAGGAGE_HEADERS", "baggage-headers", "comma separated request headers, like X-Tenant-ID, copied onto the context and into the extended and structured access logs", (*listValue)(&cfg.BaggageHeaders)},
+		{"BAGGAGE_REDACT", "baggage-redact", "comma separated baggage headers logged as [redacted], besides those named like secrets", (*listValue)(&cfg.BaggageRedact)},
+		{"MAX_CONCURRENT_REQUESTS", "max-concurrent-requests", "requests handled at once, the others queueing, 0 disables", (*intValue)(&cfg.MaxConcurrentRequests)},
+		{"QUEUE_TIMEOUT", "queue-timeout", "longest a request queues for a concurrency slot before a 503, 0 doesn't queue", &cfg.QueueTimeout},
+		{"CHAOS_ROUTES", "chaos-routes", "debug: routes to delay and fail on purpose, only along with DEBUG", (*listValue)(&cfg.ChaosRoutes)},
+		{"CHAOS_MIN_LATENCY", "chaos-min-latency", "debug: shortest delay added to chaos routes", &cfg.ChaosMinLatency},
+		{"CHAOS_MAX_LATENCY", "chaos-max-latency", "debug: longest delay added to chaos routes", &cfg.ChaosMaxLatency},
+		{"CHAOS_ERROR_PERCENT", "chaos-error-percent", "debug: share of chaos route requests failed with a 500", (*intValue)(&cfg.ChaosErrorPercent)},
+		{"ROUTE_HEADERS", "route-headers", `default response headers by route name, as JSON like {"index": {"Cache-Control": "no-cache"}}, handlers' own win`, &cfg.RouteHeaders},
+		{"SINGLE_FLIGHT_ROUTES", "single-flight-routes", "routes running their handler once for concurrent identical GETs, sharing the response", (*listValue)(&cfg.SingleFlightRoutes)},
+		{"SINGLE_FLIGHT_MAX_BODY", "single-flight-max-body", "largest response in bytes shared by single flight routes", (*intValue)(&cfg.SingleFlightMaxBody)},
+		{"ASYNC_LOG_BUFFER", "async-log-buffer", "log lines queued for writing in the background, dropping more rather than blocking, 0 writes synchronously", (*intValue)(&cfg.AsyncLogBuffer)},
+		{"HMAC_ROUTES", "hmac-routes", "routes, like webhooks, whose request bodies must be signed with HMAC_SECRET", (*listValue)(&cfg.HMACRoutes)},
+		{"HMAC_SECRET", "hmac-secret", "key of the HMAC-SHA256 request body signatures", (*stringValue)(&cfg.HMACSecret)},
+		{"HMAC_HEADER", "hmac-header", "header holding the request body signature", (*stringValue)(&cfg.HMACHeader)},
+		{"HMAC_ENCODING", "hmac-encoding", "encoding of the request body signature: hex or base64", (*stringValue)(&cfg.HMACEncoding)},
+		{"DEBUG_CONFIG", "debug-config", "serve the config in effect, secrets redacted, on /debug/config", (*boolValue)(&cfg.DebugConfig)},
+		{"ERROR_RATE_PERCENT", "error-rate-percent", "warn when a route answers over this share of 5xx within the window, served on /debug/error_rates, 0 disables", (*intValue)(&cfg.ErrorRatePercent)},
+		{"ERROR_RATE_WINDOW", "error-rate-window", "rolling window 5xx shares are counted over", &cfg.ErrorRateWindow},
+		{"ERROR_RATE_MIN_REQUESTS", "error-rate-min-requests", "fewest requests within the window before a route's 5xx share is judged", (*intValue)(&cfg.ErrorRateMinRequests)},
+		{"SEQUENCE_TTL", "sequence-ttl", "refuse X-Sequence numbers going back or skipping ahead within a session with a 409, remembering sessions this long, 0 disables", &cfg.SequenceTTL},
+		{"SEQUENCE_SESSIONS", "sequence-sessions", "most sessions whose sequence numbers are remembered", (*intValue)(&cfg.SequenceSessions)},
+		{"SEQUENCE_SESSION_HEADER", "sequence-session-header", "header naming the session of X-Sequence numbers", (*stringValue)(&cfg.SequenceSessionHeader)},
+		{"SEQUENCE_TOLERANCE", "sequence-tolerance", "how many sequence numbers a request may skip", (*intValue)(&cfg.SequenceTolerance)},
+		{"LOG_COLLAPSE_WINDOW", "log-collapse-window", "log consecutive access lines of the same method, status and path once, then their count once idle this long, 0 disables", &cfg.LogCollapseWindow},
+		{"MAX_CONNECTIONS", "max-connections", "connections open at once, idle keep-alive ones included, the others waiting to be accepted, 0 disables", (*intValue)(&cfg.MaxConnections)},
+		{"MIDDLEWARE_TIMING", "middleware-timing", "log how long each middleware took of requests this slow or slower, 0 disables", &cfg.MiddlewareTiming},
+		{"SIZE_BUCKETS", "size-buckets", "upper bounds in bytes of the request and response size histograms", (*intListValue)(&cfg.SizeBuckets)},
+		{"REQUEST_BUDGET", "request-budget", "requests in flight or queued at once, reads past it getting BUSY_PAGE and writes a 503, 0 disables", (*intValue)(&cfg.RequestBudget)},
+		{"BUSY_PAGE", "busy-page", "file served with a 503 to reads past the request budget, a short plain text one when empty", (*stringValue)(&cfg.BusyPage)},
+	}
+}
+
+func (cfg *Config) flagSet(file *string) *flag.FlagSet {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.StringVar(file, "config", "", "JSON config file")
+	for _, v := range cfg.vars() {
+		fs.Var(v.value, v.flag, v.usage+" ($"+v.env+")")
+	}
+	return fs
+}
+
+func LoadConfig(args []string) (*Config, error) {
+	var file string
+
+	// First pass only finds the config file, flags are applied again last
+	if err := DefaultConfig().flagSet(&file).Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := DefaultConfig()
+
+	if file != "" {
+		if err := cfg.loadFile(file); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.flagSet(&file).Parse(args); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func (cfg *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("config: %s: %w", path, err)
+	}
+	if unknown := unknownKeys(raw, reflect.TypeOf(*cfg), ""); len(unknown) > 0 {
+		return fmt.Errorf("config: %s: unknown keys %s", path, strings.Join(unknown, ", "))
+	}
+
+	if err := json.NewDecoder(bytes.NewReader(data)).Decode(cfg); err != nil {
+		return fmt.Errorf("config: %s: %w", path, err)
+	}
+	return nil
+}
+
+func (cfg *Config) loadEnv() error {
+	for _, v := range cfg.vars() {
+		value, ok := os.LookupEnv(v.env)
+		if !ok {
+			continue
+		}
+		if err := v.value.Set(value); err != nil {
+			return fmt.Errorf("config: invalid %s=%q: %w", v.env, value, err)
+		}
+	}
+	return nil
+}
+
+func (cfg *Config) validate() error {
+	if err := cfg.LogFormat.Validate(); err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+
+	if err := cfg.ErrorLogFormat.Validate(); err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	if cfg.ErrorLogFormat == LogFormatCommon {
+		return fmt.Errorf("config: the common log format is only for the access log")
+	}
+
+	if _, err := ParseTimePrecision(cfg.LogTimePrecision); err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	if err := ValidateLogBackend(cfg.LogBackend); err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+
+	if cfg.RequestIDTTL > 0 && cfg.RequestIDCacheSize <= 0 {
+		return fmt.Errorf("config: REQUEST_ID_TTL needs a REQUEST_ID_CACHE_SIZE above 0")
+	}
+
+	if cfg.MaxConcurrentRequests < 0 {
+		return fmt.Errorf("config: max concurrent requests %d under 0", cfg.MaxConcurrentRequests)
+	}
+	if cfg.QueueTimeout < 0 {
+		return fmt.Errorf("config: negative queue timeout")
+	}
+	// Converted to a uint64 a negative limit would never shed a request
+	if cfg.MaxHeapInuse < 0 {
+		return fmt.Errorf("config: max heap in use %d under 0", cfg.MaxHeapInuse)
+	}
+
+	if _, err := ParseRateLimitKey(cfg.RateLimitBy); err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	// Until one calls WithUser every request would count against its IP
+	if cfg.RateLimitBy == "user" {
+		return fmt.Errorf("config: RATE_LIMIT_BY=user needs an authentication middleware setting the user, and none is set up")
+	}
+
+	if cfg.ErrorPenaltyThreshold > 0 && (cfg.ErrorPenaltyDecay <= 0 || cfg.ErrorPenaltyRateLimit.Unlimited()) {
+		return fmt.Errorf("config: error penalties need a positive decay and a rate limit")
+	}
+
+	if cfg.ChaosErrorPercent < 0 || cfg.ChaosErrorPercent > 100 {
+		return fmt.Errorf("config: chaos error percent %d out of 0-100", cfg.ChaosErrorPercent)
+	}
+	if cfg.ChaosMinLatency > cfg.ChaosMaxLatency && cfg.ChaosMaxLatency > 0 {
+		return fmt.Errorf("config: chaos min latency over the max one")
+	}
+
+	if cfg.ErrorRatePercent < 0 || cfg.ErrorRatePercent > 100 {
+		return fmt.Errorf("config: error rate percent %d out of 0-100", cfg.ErrorRatePercent)
+	}
+	if cfg.ErrorRatePercent > 0 && cfg.ErrorRateWindow < Duration(time.Second) {
+		return fmt.Errorf("config: error rate window under a second")
+	}
+
+	if cfg.SequenceTTL > 0 && (cfg.SequenceSessions <= 0 || cfg.SequenceTolerance < 0) {
+		return fmt.Errorf("config: sequence checks need SEQUENCE_SESSIONS above 0 and SEQUENCE_TOLERANCE of 0 or more")
+	}
+
+	for i, size := range cfg.SizeBuckets {
+		if size <= 0 || (i > 0 && size <= cfg.SizeBuckets[i-1]) {
+			return fmt.Errorf("config: size buckets must be positive and ascending")
+		}
+	}
+
+	if err := ValidateControlChars(cfg.LogControlChars); err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+
+	if err := ValidateHMACEncoding(cfg.HMACEncoding); err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	if len(cfg.HMACRoutes) > 0 && cfg.HMACSecret == "" {
+		return fmt.Errorf("config: HMAC_ROUTES need an HMAC_SECRET")
+	}
+
+	if _, err := ColorByName(cfg.LogSeparatorColor); err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+
+	if err := ValidateCanonicalMode(cfg.CanonicalPaths); err != nil {
+		return err
+	}
+
+	if err := ValidateGzipLevel(cfg.GzipLevel); err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+
+	if _, err := ParseSocketMode(cfg.SocketMode); err != nil {
+		return err
+	}
+
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return fmt.Errorf("config: TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if cfg.TLSCertDir != "" && cfg.TLSCertFile != "" {
+		return fmt.Errorf("config: TLS_CERT_DIR and TLS_CERT_FILE can't be set together")
+	}
+
+	return nil
+}
+
+// unknownKeys walks a decoded JSON object against the json tags of t and
+// returns every key that doesn't map to a field, as dotted paths.
+func unknownKeys(raw map[string]interface{}, t reflect.Type, prefix string) []string {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields[name] = t.Field(i).Type
+		}
+	}
+
+	unknown := []string{}
+	for key, value := range raw {
+		field, ok := fields[key]
+		if !ok {
+			unknown = append(unknown, prefix+key)
+			continue
+		}
+		if nested, ok := value.(map[string]interface{}); ok && field.Kind() == reflect.Struct {
+			unknown = append(unknown, unknownKeys(nested, field, prefix+key+".")...)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+type stringValue string
+
+func (s *stringValue) Set(v string) error {
+	*s = stringValue(v)
+	return nil
+}
+
+func (s *stringValue) String() string { return string(*s) }
+
+// portValue sets the port of a host:port address, keeping its host
+type portValue string
+
+func (p *portValue) Set(v string) error {
+	port, err := strconv.Atoi(v)
+	if err != nil || port < 0 || port > 65535 {
+		return fmt.Errorf("port %q out of 0-65535", v)
+	}
+	if _, unix := SocketPath(string(*p)); unix {
+		return fmt.Errorf("can't set the port of the unix socket %s", string(*p))
+	}
+	host, _, err := net.SplitHostPort(string(*p))
+	if err != nil {
+		return err
+	}
+	*p = portValue(net.JoinHostPort(host, strconv.Itoa(port)))
+	return nil
+}
+
+func (p *portValue) String() string {
+	_, port, _ := net.SplitHostPort(string(*p))
+	return port
+}
+
+type intValue int
+
+func (i *intValue) Set(v string) error {
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return err
+	}
+	*i = intValue(n)
+	return nil
+}
+
+func (i *intValue) String() string { return strconv.Itoa(int(*i)) }
+
+type boolValue bool
+
+func (b *boolValue) Set(v string) error {
+	p, err := strconv.ParseBool(v)
+	if err != nil {
+		return err
+	}
+	*b = boolValue(p)
+	return nil
+}
+
+func (b *boolValue) String() string { return strconv.FormatBool(bool(*b)) }
+
+func (b *boolValue) IsBoolFlag() bool { return true }
+
+// listValue is a comma separated list, set as a whole rather than appended to
+type listValue []string
+
+func (l *listValue) Set(v string) error {
+	list := []string{}
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	*l = list
+	return nil
+}
+
+func (l *listValue) String() string { return strings.Join(*l, ",") }
+
+// intListValue is a comma separated list of integers
+type intListValue []int
+
+func (l *intListValue) Set(v string) error {
+	list := []int{}
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		n, err := strconv.Atoi(item)
+		if err != nil {
+			return err
+		}
+		list = append(list, n)
+	}
+	*l = list
+	return nil
+}
+
+func (l *intListValue) String() string {
+	items := []string{}
+	for _, n := range *l {
+		items = append(items, strconv.Itoa(n))
+	}
+	return strings.Join(items, ",")
+}
+
+// Duration reads as a time.ParseDuration string from env, flags and JSON
+type Duration time.Duration
+
+func (d *Duration) Set(v string) error {
+	p, err := time.ParseDuration(v)
+	if err != nil {
+		return err
+	}
+	*d = Duration(p)
+	return nil
+}
+
+func (d *Duration) String() string { return time.Duration(*d).String() }
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	return d.Set(s)
+}
+
+// durationMapValue is a comma separated list of name=duration pairs
+type durationMapValue map[string]Duration
+
+func (m *durationMapValue) Set(v string) error {
+	durations := map[string]Duration{}
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return fmt.Errorf("expected name=duration, got %q", item)
+		}
+		var d Duration
+		if err := d.Set(value); err != nil {
+			return err
+		}
+		durations[strings.TrimSpace(name)] = d
+	}
+	*m = durations
+	return nil
+}
+
+func (m *durationMapValue) String() string {
+	pairs := []string{}
+	for name, d := range *m {
+		pairs = append(pairs, name+"="+d.String())
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
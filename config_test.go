@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+func TestPortEnv(t *testing.T) {
+	tests := []struct {
+		listenAddr, port string
+		want             string
+		fails            bool
+	}{
+		{"", "0", "127.0.0.1:0", false},
+		{"0.0.0.0:9000", "8080", "0.0.0.0:8080", false},
+		{"[::1]:9000", "8080", "[::1]:8080", false},
+		{"", "http", "", true},
+		{"", "70000", "", true},
+		{"unix:/tmp/reststd.sock", "8080", "", true},
+	}
+	for _, tt := range tests {
+		// Subtests, so each one's environment is reset after it
+		t.Run(tt.listenAddr+" "+tt.port, func(t *testing.T) {
+			if tt.listenAddr != "" {
+				t.Setenv("LISTEN_ADDR", tt.listenAddr)
+			}
+			t.Setenv("PORT", tt.port)
+
+			cfg, err := LoadConfig(nil)
+			switch {
+			case tt.fails && err == nil:
+				t.Errorf("accepted as %s", cfg.Addr)
+			case !tt.fails && err != nil:
+				t.Error(err)
+			case !tt.fails && cfg.Addr != tt.want:
+				t.Errorf("got %s, want %s", cfg.Addr, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxHeapInuseValidated(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxHeapInuse = -1
+	if err := cfg.validate(); err == nil {
+		t.Error("negative max heap in use accepted")
+	}
+	cfg.MaxHeapInuse = 0
+	if err := cfg.validate(); err != nil {
+		t.Errorf("max heap in use of 0 refused: %v", err)
+	}
+}
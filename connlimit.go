@@ -0,0 +1,82 @@
+package main
+
+import (
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// limitListener accepts at most cap(slots) connections at once, leaving the
+// others in the kernel's backlog until some close
+type limitListener struct {
+	net.Listener
+	slots     chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	capped    atomic.Bool
+}
+
+// LimitListener caps the connections l has open at once to max, logging
+// each time the cap is hit. A max of 0 leaves l unlimited.
+func LimitListener(l net.Listener, max int) net.Listener {
+	if max <= 0 {
+		return l
+	}
+	return &limitListener{
+		Listener: l,
+		slots:    make(chan struct{}, max),
+		done:     make(chan struct{}),
+	}
+}
+
+func (ll *limitListener) acquire() bool {
+	select {
+	case ll.slots <- struct{}{}:
+		ll.capped.Store(false)
+		return true
+	default:
+	}
+
+	if !ll.capped.Swap(true) {
+		log.Println("| Connection cap of " + strconv.Itoa(cap(ll.slots)) + " reached, accepting more once some close")
+	}
+	select {
+	case ll.slots <- struct{}{}:
+		return true
+	case <-ll.done:
+		return false
+	}
+}
+
+func (ll *limitListener) Accept() (net.Conn, error) {
+	if !ll.acquire() {
+		return nil, net.ErrClosed
+	}
+	c, err := ll.Listener.Accept()
+	if err != nil {
+		<-ll.slots
+		return nil, err
+	}
+	return &limitConn{Conn: c, release: func() { <-ll.slots }}, nil
+}
+
+func (ll *limitListener) Close() error {
+	err := ll.Listener.Close()
+	ll.closeOnce.Do(func() { close(ll.done) })
+	return err
+}
+
+// limitConn gives its slot back once closed, however many times it is
+type limitConn struct {
+	net.Conn
+	releaseOnce sync.Once
+	release     func()
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.releaseOnce.Do(c.release)
+	return err
+}
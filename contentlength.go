@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gorilla/mux"
+)
+
+// lengthBody counts the bytes read through it and whether the client's body
+// ended, cleanly or not. Like countingBody the handler may still be reading
+// from its own goroutine when it's checked.
+type lengthBody struct {
+	io.ReadCloser
+	n     atomic.Int64
+	ended atomic.Bool
+}
+
+func (b *lengthBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n.Add(int64(n))
+
+	var maxBytes *http.MaxBytesError
+	if err != nil && !errors.As(err, &maxBytes) {
+		b.ended.Store(true)
+	}
+	return n, err
+}
+
+// ContentLengthMiddleware answers a 413 to requests declaring a body over
+// maxBytes, before anything reads it, and caps undeclared chunked bodies at
+// maxBytes as they are read, 0 disabling both. net/http already refuses
+// conflicting Content-Length headers, but not a body that ends before its
+// declared length, so when the handler read the body to its end and got
+// another length than declared a warning is logged, as it may be an attempt
+// at request smuggling through a proxy parsing it differently.
+func ContentLengthMiddleware(maxBytes int64) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if maxBytes > 0 && r.ContentLength > maxBytes {
+				Reject(
+					w, r, http.StatusRequestEntityTooLarge,
+					fmt.Sprintf("Content-Length %d over %d bytes", r.ContentLength, maxBytes),
+				)
+				return
+			}
+			if maxBytes > 0 {
+				r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+			}
+			if r.ContentLength <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			body := &lengthBody{ReadCloser: r.Body}
+			r.Body = body
+			next.ServeHTTP(w, r)
+
+			if n := body.n.Load(); body.ended.Load() && n != r.ContentLength {
+				LoggerFromContext(r).Printf(
+					"Content-Length mismatch: declared %d bytes, read %d", r.ContentLength, n,
+				)
+			}
+		})
+	}
+}
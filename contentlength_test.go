@@ -0,0 +1,27 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestContentLengthMismatch(t *testing.T) {
+	errs := captureLog(t, errorLog)
+	errorLog.SetFormat(LogFormatDefault)
+
+	handler := RequestIDMiddleware(ContentLengthMiddleware(0)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+	})))
+	r := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader("abcd"))
+	r.ContentLength = 10
+	r.Header.Set(RequestIDHeader, "0123456789abcdef")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	want := "| 0123456789abcdef | POST    | /upload | Content-Length mismatch: declared 10 bytes, read 4\n"
+	if !strings.HasSuffix(errs.String(), want) {
+		t.Errorf("got %q, want it ending in %q", errs.String(), want)
+	}
+}
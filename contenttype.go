@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// ContentTypeCheckMiddleware warns about responses sent with a body but no
+// Content-Type, left for net/http, or gzip, to sniff from the first bytes.
+// fallback, when set, is sent instead of sniffing. A Content-Type set to nil
+// counts as the handler opting out of sniffing on purpose.
+func ContentTypeCheckMiddleware(enabled bool, fallback string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		if !enabled {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			writer, ok := w.(*ResponseRecorderWriter)
+			if !ok {
+				writer = &ResponseRecorderWriter{ResponseWriter: w, Status: http.StatusOK}
+			}
+
+			var missing bool
+			writer.OnWriteHeader(func(status int, header http.Header) {
+				if _, ok := header["Content-Type"]; ok || !bodyAllowed(status) {
+					return
+				}
+				missing = true
+				if fallback != "" {
+					header.Set("Content-Type", fallback)
+				}
+			})
+
+			bytes := writer.Bytes
+			next.ServeHTTP(writer, r)
+
+			if missing && writer.Bytes > bytes {
+				if fallback != "" {
+					LoggerFromContext(r).Printf("response without a Content-Type on route %s, sent as %s", RouteName(r), fallback)
+				} else {
+					LoggerFromContext(r).Printf("response without a Content-Type on route %s, left to sniffing", RouteName(r))
+				}
+			}
+		})
+	}
+}
+
+// bodyAllowed tells whether a response with status can have a body at all
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
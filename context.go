@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	loggerKey
+	startKey
+	acceptedTypeKey
+	geoKey
+	bodySizeKey
+	csrfKey
+	apiVersionKey
+	userKey
+	queueTimeKey
+	signedKey
+	handshakeKey
+	timingsKey
+	baggageKey
+)
+
+const RequestIDHeader string = "X-Request-ID"
+
+var validRequestID *regexp.Regexp = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+func NewRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "-"
+	}
+	return hex.EncodeToString(b)
+}
+
+func RequestIDFromContext(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey).(string)
+	return id
+}
+
+// WithUser records who the request authenticated as, for an authentication
+// middleware to call once the credentials are verified. It must run before
+// anything reading it, like the per user rate limit, which runs before
+// routing.
+func WithUser(r *http.Request, user string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), userKey, user))
+}
+
+// UserFromContext is the authenticated user, "" for anonymous requests.
+// Unlike basicAuthUser it's never just what the client claims.
+func UserFromContext(r *http.Request) string {
+	user, _ := r.Context().Value(userKey).(string)
+	return user
+}
+
+// RequestStart is when the request entered the middleware chain, so every
+// middleware measures its duration from the same instant.
+func RequestStart(r *http.Request) time.Time {
+	if start, ok := r.Context().Value(startKey).(time.Time); ok {
+		return start
+	}
+	return time.Now()
+}
+
+// RequestMessage is a line logged through a request's logger, printed by the
+// error log in its format, at info, under the request's ID, method and path
+type RequestMessage struct {
+	RequestID string
+	Method    string
+	Path      string
+	Message   string
+}
+
+func (m RequestMessage) Format(format LogFormat) string {
+	if format == LogFormatJSON {
+		b, _ := json.Marshal(map[string]interface{}{
+			"id":      m.RequestID,
+			"method":  m.Method,
+			"path":    m.Path,
+			"message": m.Message,
+		})
+		return string(b)
+	}
+	if format == LogFormatLogfmt {
+		return logfmtString("id", m.RequestID, "method", m.Method, "path", m.Path, "message", m.Message)
+	}
+	rl := NewRequestLoggerBuilder().SetRequestID(m.RequestID)
+	return rl.joinColumns(rl.pad(7, m.Method), sanitize(m.Path), m.Message)
+}
+
+// requestLogWriter prints each line of a request's logger to the error log
+type requestLogWriter struct {
+	request RequestMessage
+}
+
+func (rw requestLogWriter) Write(b []byte) (int, error) {
+	if errorLog.Enabled(LevelInfo) {
+		m := rw.request
+		m.Message = strings.TrimSuffix(string(b), "\n")
+		errorLog.Print(LevelInfo, m)
+	}
+	return len(b), nil
+}
+
+// LoggerFromContext is the logger RequestIDMiddleware attached, the log
+// package's outside of it
+func LoggerFromContext(r *http.Request) *log.Logger {
+	if logger, ok := r.Context().Value(loggerKey).(*log.Logger); ok {
+		return logger
+	}
+	return log.Default()
+}
+
+// RequestIDMiddleware reuses a well formed incoming X-Request-ID or generates
+// one, and attaches it, along with a logger whose lines go to the error log
+// under it and the request start time, to the context.
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = NewRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		logger := log.New(requestLogWriter{RequestMessage{
+			RequestID: id,
+			Method:    r.Method,
+			Path:      r.URL.Path,
+		}}, "", 0)
+
+		ctx := context.WithValue(r.Context(), requestIDKey, id)
+		ctx = context.WithValue(ctx, loggerKey, logger)
+		ctx = context.WithValue(ctx, startKey, start)
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func serveLogging(id string) {
+	handler := RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		LoggerFromContext(r).Printf("looked up %d items", 3)
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/items", nil)
+	r.Header.Set(RequestIDHeader, id)
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+}
+
+func TestRequestLoggerFields(t *testing.T) {
+	errs := captureLog(t, errorLog)
+	serveLogging("0123456789abcdef")
+
+	lines := errs.Lines(t)
+	if len(lines) != 1 {
+		t.Fatalf("got lines %v, want one", lines)
+	}
+	for key, want := range map[string]string{
+		"id":      "0123456789abcdef",
+		"method":  http.MethodGet,
+		"path":    "/items",
+		"message": "looked up 3 items",
+	} {
+		if lines[0][key] != want {
+			t.Errorf("got %s %v, want %q", key, lines[0][key], want)
+		}
+	}
+	if _, ok := lines[0]["time"]; !ok {
+		t.Errorf("no time at millisecond precision in %v", lines[0])
+	}
+}
+
+func TestRequestLoggerFormats(t *testing.T) {
+	errs := captureLog(t, errorLog)
+
+	errorLog.SetFormat(LogFormatLogfmt)
+	serveLogging("0123456789abcdef")
+	if want := `id=0123456789abcdef method=GET path=/items message="looked up 3 items"`; !strings.Contains(errs.String(), want) {
+		t.Errorf("got %q, want %q", errs.String(), want)
+	}
+
+	errs.buf.Reset()
+	errorLog.SetFormat(LogFormatDefault)
+	serveLogging("0123456789abcdef")
+	if want := "| 0123456789abcdef | GET     | /items | looked up 3 items\n"; !strings.HasSuffix(errs.String(), want) {
+		t.Errorf("got %q, want it ending in %q", errs.String(), want)
+	}
+
+	errs.buf.Reset()
+	errorLog.SetLevel(LevelWarn)
+	t.Cleanup(func() { errorLog.SetLevel(LevelInfo) })
+	serveLogging("0123456789abcdef")
+	if errs.String() != "" {
+		t.Errorf("got %q under the warn level", errs.String())
+	}
+}
+
+func TestRequestLoggerSlog(t *testing.T) {
+	var buf bytes.Buffer
+	errorLog.SetSlog(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { errorLog.SetSlog(nil) })
+
+	serveLogging("0123456789abcdef")
+
+	record := map[string]interface{}{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("slog record %q: %v", buf.String(), err)
+	}
+	if record["msg"] != "looked up 3 items" || record["id"] != "0123456789abcdef" ||
+		record["level"] != "INFO" || record["path"] != "/items" {
+		t.Errorf("got record %v", record)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"X-Api-Key":           true,
+	"X-Auth-Token":        true,
+	"X-Csrf-Token":        true,
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// CurlCommand renders r as a curl command line reproducing it, leaving out
+// credentials and with a placeholder in place of the body.
+func CurlCommand(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	parts := []string{"curl", "-X", r.Method}
+
+	names := make([]string, 0, len(r.Header))
+	for name := range r.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if name == "Content-Length" {
+			continue
+		}
+		if sensitiveHeaders[http.CanonicalHeaderKey(name)] {
+			parts = append(parts, "-H", shellQuote(name+": [REDACTED]"))
+			continue
+		}
+		for _, value := range r.Header[name] {
+			parts = append(parts, "-H", shellQuote(name+": "+value))
+		}
+	}
+
+	if r.Body != nil && r.Body != http.NoBody {
+		parts = append(parts, "--data-binary", "@body")
+	}
+
+	parts = append(parts, shellQuote(scheme+"://"+r.Host+r.URL.RequestURI()))
+	return strings.Join(parts, " ")
+}
+
+// CurlLogMiddleware is a debugging aid logging a replayable curl command for
+// requests under one of prefixes.
+func CurlLogMiddleware(prefixes ...string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, prefix := range prefixes {
+				if strings.HasPrefix(r.URL.Path, prefix) {
+					LoggerFromContext(r).Println(CurlCommand(r))
+					break
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
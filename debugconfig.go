@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+)
+
+const Redacted string = "[redacted]"
+
+// sensitiveKey matches the config keys, and the header names within them
+// like route_headers' ones, whose values are kept out of /debug/config. The
+// TLS certificate directory holds the key, as tls.key.
+var sensitiveKey *regexp.Regexp = regexp.MustCompile(
+	`(?i)secret|token|password|passwd|key|credential|authorization|cookie|signature|^tls_cert_dir$`,
+)
+
+// redact replaces the non empty values of sensitive keys at any depth
+func redact(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if sensitiveKey.MatchString(key) && !isEmpty(value) {
+				v[key] = Redacted
+			} else {
+				v[key] = redact(value)
+			}
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = redact(value)
+		}
+	}
+	return v
+}
+
+// isEmpty tells unset values apart, so the output still shows a secret
+// wasn't configured
+func isEmpty(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case []interface{}:
+		return len(v) == 0
+	case map[string]interface{}:
+		return len(v) == 0
+	}
+	return false
+}
+
+// RedactedConfig is cfg as its JSON config file keys, with every secret,
+// like the admin token, the CSRF and HMAC secrets and the TLS key path and
+// directory, replaced by [redacted]
+func RedactedConfig(cfg *Config) (map[string]interface{}, error) {
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, err
+	}
+	redact(raw)
+	return raw, nil
+}
+
+// ConfigHandler serves the config in effect, reloads included, to check how
+// defaults, the config file, env and flags resolved
+func ConfigHandler(w http.ResponseWriter, r *http.Request) {
+	raw, err := RedactedConfig(currentConfig.Load())
+	if err != nil {
+		LoggerFromContext(r).Printf("config: %v", err)
+		writeError(w, r, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+	writeJSON(w, http.StatusOK, raw)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRedactedConfig(t *testing.T) {
+	cfg := DefaultConfig()
+	secrets := []string{
+		"admin-token-value",
+		"csrf-secret-value",
+		"hmac-secret-value",
+		"/etc/tls/private.key",
+		"/etc/tls/secret-dir",
+		"Bearer route-header-token",
+		"route-header-api-key",
+		"route-header-cookie",
+	}
+	cfg.AdminToken = secrets[0]
+	cfg.CSRFSecret = secrets[1]
+	cfg.HMACSecret = secrets[2]
+	cfg.TLSKeyFile = secrets[3]
+	cfg.TLSCertDir = secrets[4]
+	cfg.RouteHeaders = RouteHeaders{"api": {
+		"Authorization": secrets[5],
+		"X-Api-Key":     secrets[6],
+		"Set-Cookie":    secrets[7],
+		"Cache-Control": "no-store",
+	}}
+
+	redacted, err := RedactedConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(redacted)
+	out := string(b)
+	for _, secret := range secrets {
+		if strings.Contains(out, secret) {
+			t.Errorf("%q left in %s", secret, out)
+		}
+	}
+	for _, kept := range []string{`"no-store"`, `"listen_addr"`} {
+		if !strings.Contains(out, kept) {
+			t.Errorf("%s redacted out of %s", kept, out)
+		}
+	}
+	if redacted["admin_token"] != Redacted || redacted["tls_cert_dir"] != Redacted {
+		t.Errorf("got admin_token %v and tls_cert_dir %v, want them %s",
+			redacted["admin_token"], redacted["tls_cert_dir"], Redacted)
+	}
+
+	// Unset secrets show as such
+	redacted, _ = RedactedConfig(DefaultConfig())
+	if redacted["admin_token"] != "" {
+		t.Errorf("got unset admin_token %v, want it empty", redacted["admin_token"])
+	}
+}
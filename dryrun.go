@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// DryRunMiddleware logs which route each request matched and answers a 204
+// instead of running its handler, to check a routing table safely. Health
+// checks still run, so the server isn't restarted for failing them.
+func DryRunMiddleware(enabled bool) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		if !enabled {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if IsHealthCheck(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			LoggerFromContext(r).Printf(
+				"%sdry run%s matched route %s %s",
+				colors.Cyan, colors.Reset, RouteName(r), RouteTemplate(r),
+			)
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// errorRateBuckets is how many slices a window is counted in, each route
+// keeping just these whatever its traffic
+const errorRateBuckets int = 10
+
+type statusBucket struct {
+	slot    int64
+	classes [6]uint64
+}
+
+type routeStatuses struct {
+	buckets  [errorRateBuckets]statusBucket
+	alerting bool
+}
+
+// ErrorRates counts statuses per route over a rolling window and warns when
+// a route's share of 5xx goes over its threshold, then once it's back under.
+// A nil *ErrorRates counts nothing.
+type ErrorRates struct {
+	mu          sync.Mutex
+	routes      map[string]*routeStatuses
+	window      time.Duration
+	percent     int
+	minRequests uint64
+}
+
+var errorRates *ErrorRates
+
+// NewErrorRates warns about routes answering over percent 5xx within
+// window, once they had at least minRequests, nil when percent is 0
+func NewErrorRates(percent int, window time.Duration, minRequests int) *ErrorRates {
+	if percent <= 0 || window <= 0 {
+		return nil
+	}
+	return &ErrorRates{
+		routes:      map[string]*routeStatuses{},
+		window:      window,
+		percent:     percent,
+		minRequests: uint64(minRequests),
+	}
+}
+
+func (er *ErrorRates) slot(now time.Time) int64 {
+	return now.UnixNano() / int64(er.window/time.Duration(errorRateBuckets))
+}
+
+// counts sums the buckets still within the window ending at slot
+func (er *ErrorRates) counts(rs *routeStatuses, slot int64) (classes [6]uint64, total uint64) {
+	for _, b := range rs.buckets {
+		if slot-b.slot >= int64(errorRateBuckets) {
+			continue
+		}
+		for class, n := range b.classes {
+			classes[class] += n
+			total += n
+		}
+	}
+	return classes, total
+}
+
+func (er *ErrorRates) Observe(route string, status int) {
+	class := status / 100
+	if er == nil || class < 0 || class >= 6 {
+		return
+	}
+	er.mu.Lock()
+	defer er.mu.Unlock()
+
+	rs, ok := er.routes[route]
+	if !ok {
+		rs = &routeStatuses{}
+		er.routes[route] = rs
+	}
+
+	slot := er.slot(time.Now())
+	b := &rs.buckets[slot%int64(errorRateBuckets)]
+	if b.slot != slot {
+		*b = statusBucket{slot: slot}
+	}
+	b.classes[class]++
+
+	// Too few requests to judge either way, the route keeps its state
+	classes, total := er.counts(rs, slot)
+	if total < er.minRequests {
+		return
+	}
+	over := classes[5]*100 > uint64(er.percent)*total
+	if over == rs.alerting {
+		return
+	}
+	rs.alerting = over
+
+	alert := ErrorRateAlert{route, classes[5], total, er.window, over}
+	if over {
+		errorLog.Print(LevelWarn, alert)
+	} else {
+		errorLog.Print(LevelInfo, alert)
+	}
+}
+
+// RouteErrorRate is a route's counts over the current window
+type RouteErrorRate struct {
+	Route    string            `json:"route"`
+	Total    uint64            `json:"total"`
+	Statuses map[string]uint64 `json:"statuses"`
+	Ratio5xx float64           `json:"ratio_5xx"`
+	Alerting bool              `json:"alerting"`
+}
+
+// List returns every route seen within the window, by name
+func (er *ErrorRates) List() []RouteErrorRate {
+	rates := []RouteErrorRate{}
+	if er == nil {
+		return rates
+	}
+	er.mu.Lock()
+	defer er.mu.Unlock()
+
+	slot := er.slot(time.Now())
+	for route, rs := range er.routes {
+		classes, total := er.counts(rs, slot)
+		if total == 0 {
+			continue
+		}
+		rate := RouteErrorRate{
+			Route:    route,
+			Total:    total,
+			Statuses: map[string]uint64{},
+			Ratio5xx: float64(classes[5]) / float64(total),
+			Alerting: rs.alerting,
+		}
+		for class, n := range classes {
+			if n > 0 {
+				rate.Statuses[fmt.Sprintf("%dxx", class)] = n
+			}
+		}
+		rates = append(rates, rate)
+	}
+	sort.Slice(rates, func(i, j int) bool { return rates[i].Route < rates[j].Route })
+	return rates
+}
+
+// ErrorRatesHandler serves the current window's counts per route as JSON
+func ErrorRatesHandler(er *ErrorRates) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, er.List())
+	}
+}
+
+// ErrorRateAlert is logged as a route's 5xx share crosses the threshold,
+// either way
+type ErrorRateAlert struct {
+	Route    string
+	Errors   uint64
+	Total    uint64
+	Window   time.Duration
+	Alerting bool
+}
+
+func (a ErrorRateAlert) Format(format LogFormat) string {
+	if format == LogFormatJSON {
+		b, _ := json.Marshal(map[string]interface{}{
+			"route":     a.Route,
+			"errors":    a.Errors,
+			"total":     a.Total,
+			"window_ms": float64(a.Window) / float64(time.Millisecond),
+			"alerting":  a.Alerting,
+		})
+		return string(b)
+	}
+	if format == LogFormatLogfmt {
+		return logfmtString(
+			"route", a.Route, "errors", a.Errors, "total", a.Total,
+			"window", a.Window, "alerting", a.Alerting,
+		)
+	}
+	sep := logTheme.Sep()
+	if !a.Alerting {
+		return fmt.Sprintf(
+			"%s %s5xx%s back under threshold on %s %s %d of %d in the last %s",
+			sep, colors.Green, colors.Reset, a.Route, sep, a.Errors, a.Total, a.Window,
+		)
+	}
+	return fmt.Sprintf(
+		"%s %s5xx%s over threshold on %s %s %d of %d in the last %s",
+		sep, colors.Red, colors.Reset, a.Route, sep, a.Errors, a.Total, a.Window,
+	)
+}
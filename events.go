@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// EventsHandler streams a server sent event every second until the client
+// goes away or the server shuts down, in which case it says goodbye and
+// returns so the shutdown doesn't have to wait for its deadline.
+func EventsHandler(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+
+	// The stream outlives the server's WriteTimeout
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		LoggerFromContext(r).Println(err)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ShutdownContext().Done():
+			fmt.Fprint(w, "event: shutdown\ndata: bye\n\n")
+			rc.Flush()
+			return
+		case t := <-ticker.C:
+			fmt.Fprintf(w, "data: %s\n\n", t.Format(time.RFC3339))
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Favicon is an icon held in memory, whether embedded with go:embed or read
+// from disk with LoadFavicon. A nil *Favicon is served as a 204, which
+// browsers accept without asking again on every page.
+type Favicon struct {
+	content     []byte
+	contentType string
+	modTime     time.Time
+}
+
+func NewFavicon(content []byte, contentType string, modTime time.Time) *Favicon {
+	if contentType == "" {
+		contentType = http.DetectContentType(content)
+	}
+	return &Favicon{content: content, contentType: contentType, modTime: modTime}
+}
+
+// LoadFavicon reads the icon at path once, returning nil when path is empty
+func LoadFavicon(path string) (*Favicon, error) {
+	if path == "" {
+		return nil, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("favicon: %w", err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("favicon: %w", err)
+	}
+	return NewFavicon(content, mime.TypeByExtension(filepath.Ext(path)), info.ModTime()), nil
+}
+
+// FaviconHandler serves f with a day of caching, conditional requests
+// answered by http.ServeContent
+func FaviconHandler(f *Favicon) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		if f == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", f.contentType)
+		http.ServeContent(w, r, "", f.modTime, bytes.NewReader(f.content))
+	}
+}
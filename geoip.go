@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// geoCacheSize bounds the lookup cache, it's cleared when full
+const geoCacheSize int = 10000
+
+// Geo is the coarse location of a client, either field is "" when unknown
+type Geo struct {
+	Country string
+	Region  string
+}
+
+// GeoIP looks up client IPs in a MaxMind GeoIP2 or GeoLite2 Country or City
+// database. A nil *GeoIP finds nothing.
+type GeoIP struct {
+	reader *geoip2.Reader
+	city   bool
+
+	mu    sync.Mutex
+	cache map[string]Geo
+}
+
+// OpenGeoIP opens the database at path, returning nil when path is empty
+func OpenGeoIP(path string) (*GeoIP, error) {
+	if path == "" {
+		return nil, nil
+	}
+	reader, err := geoip2.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("geoip: %w", err)
+	}
+	return &GeoIP{
+		reader: reader,
+		city:   strings.Contains(reader.Metadata().DatabaseType, "City"),
+		cache:  map[string]Geo{},
+	}, nil
+}
+
+func (g *GeoIP) Close() error {
+	if g == nil {
+		return nil
+	}
+	return g.reader.Close()
+}
+
+// Lookup finds where ip is, skipping private and loopback addresses which
+// no database knows about
+func (g *GeoIP) Lookup(ip net.IP) Geo {
+	if g == nil || ip == nil || ip.IsPrivate() || ip.IsLoopback() ||
+		ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+		return Geo{}
+	}
+
+	key := ip.String()
+
+	g.mu.Lock()
+	geo, ok := g.cache[key]
+	g.mu.Unlock()
+	if ok {
+		return geo
+	}
+
+	if g.city {
+		if record, err := g.reader.City(ip); err == nil {
+			geo.Country = record.Country.IsoCode
+			if len(record.Subdivisions) > 0 {
+				geo.Region = record.Subdivisions[0].IsoCode
+			}
+		}
+	} else if record, err := g.reader.Country(ip); err == nil {
+		geo.Country = record.Country.IsoCode
+	}
+
+	g.mu.Lock()
+	if len(g.cache) >= geoCacheSize {
+		g.cache = map[string]Geo{}
+	}
+	g.cache[key] = geo
+	g.mu.Unlock()
+
+	return geo
+}
+
+// ClientIP is the address the request came from
+func ClientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+func GeoFromContext(r *http.Request) Geo {
+	geo, _ := r.Context().Value(geoKey).(Geo)
+	return geo
+}
+
+// GeoIPMiddleware attaches the client's location to the context, for the
+// access log. It's a no-op without a database.
+func GeoIPMiddleware(g *GeoIP) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if g == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			geo := g.Lookup(ClientIP(r))
+			if geo != (Geo{}) {
+				r = r.WithContext(context.WithValue(r.Context(), geoKey, geo))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -2,4 +2,13 @@ module reststd
 
 go 1.21.3
 
-require github.com/gorilla/mux v1.8.0 // indirect
+require (
+	github.com/gorilla/mux v1.8.0
+	github.com/oschwald/geoip2-golang v1.11.0
+	golang.org/x/sync v0.7.0
+)
+
+require (
+	github.com/oschwald/maxminddb-golang v1.13.0 // indirect
+	golang.org/x/sys v0.20.0 // indirect
+)
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gorilla/mux"
+)
+
+// PathRule returns a non empty reason when the decoded path must be refused.
+type PathRule func(path string) string
+
+func TraversalRule(path string) string {
+	for _, segment := range strings.FieldsFunc(path, func(r rune) bool {
+		return r == '/' || r == '\\'
+	}) {
+		if segment == ".." {
+			return "path traversal"
+		}
+	}
+	return ""
+}
+
+func ControlCharRule(path string) string {
+	if strings.IndexFunc(path, unicode.IsControl) >= 0 {
+		return "control character in path"
+	}
+	return ""
+}
+
+func MaxPathLengthRule(max int) PathRule {
+	return func(path string) string {
+		if len(path) > max {
+			return fmt.Sprintf("path longer than %d bytes", max)
+		}
+		return ""
+	}
+}
+
+func NewPathRules(names []string, maxLength int) ([]PathRule, error) {
+	rules := []PathRule{}
+	for _, name := range names {
+		switch name {
+		case "traversal":
+			rules = append(rules, TraversalRule)
+		case "control":
+			rules = append(rules, ControlCharRule)
+		case "length":
+			rules = append(rules, MaxPathLengthRule(maxLength))
+		default:
+			return nil, fmt.Errorf("config: unknown path rule %q", name)
+		}
+	}
+	return rules, nil
+}
+
+func PathGuardMiddleware(rules ...PathRule) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, rule := range rules {
+				if reason := rule(r.URL.Path); reason != "" {
+					rejectPath(
+						w, r, strconv.Quote(r.URL.Path),
+						http.StatusBadRequest, reason,
+					)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// ValidateGzipLevel accepts gzip.BestSpeed (1) to gzip.BestCompression (9),
+// and 0 for no compression at all
+func ValidateGzipLevel(level int) error {
+	if level != 0 && (level < gzip.BestSpeed || level > gzip.BestCompression) {
+		return fmt.Errorf("gzip level %d out of 1-9", level)
+	}
+	return nil
+}
+
+// acceptsGzip reads Accept-Encoding like Accept, gzip or * with a q above 0
+func acceptsGzip(r *http.Request) bool {
+	for _, ar := range parseAccept(r.Header.Get("Accept-Encoding")) {
+		if (ar.mediaType == "gzip" || ar.mediaType == "*") && ar.q > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// gzipWriter holds the status until the first write, so the Content-Type
+// can be sniffed from the uncompressed bytes, then compresses the body unless
+// the response has none or is already encoded
+type gzipWriter struct {
+	http.ResponseWriter
+	pool        *sync.Pool
+	gz          *gzip.Writer
+	status      int
+	wroteHeader bool
+}
+
+func (gw *gzipWriter) WriteHeader(status int) {
+	if status < 200 {
+		gw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if gw.status == 0 {
+		gw.status = status
+	}
+}
+
+func (gw *gzipWriter) writeHeader(b []byte) {
+	if gw.wroteHeader {
+		return
+	}
+	gw.wroteHeader = true
+	if gw.status == 0 {
+		gw.status = http.StatusOK
+	}
+
+	h := gw.Header()
+	if h.Get("Content-Type") == "" && len(b) > 0 {
+		h.Set("Content-Type", http.DetectContentType(b))
+	}
+	if h.Get("Content-Encoding") == "" &&
+		gw.status != http.StatusNoContent && gw.status != http.StatusNotModified {
+		h.Set("Content-Encoding", "gzip")
+		h.Add("Vary", "Accept-Encoding")
+		h.Del("Content-Length")
+
+		gw.gz = gw.pool.Get().(*gzip.Writer)
+		gw.gz.Reset(gw.ResponseWriter)
+	}
+
+	gw.ResponseWriter.WriteHeader(gw.status)
+}
+
+func (gw *gzipWriter) Write(b []byte) (int, error) {
+	gw.writeHeader(b)
+	if gw.gz == nil {
+		return gw.ResponseWriter.Write(b)
+	}
+	return gw.gz.Write(b)
+}
+
+func (gw *gzipWriter) Flush() {
+	gw.writeHeader(nil)
+	if gw.gz != nil {
+		gw.gz.Flush()
+	}
+	if f, ok := gw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// close sends a status the handler set without writing a body, then ends
+// the gzip stream and returns its writer to the pool
+func (gw *gzipWriter) close() {
+	if gw.status != 0 {
+		gw.writeHeader(nil)
+	}
+	if gw.gz == nil {
+		return
+	}
+	gw.gz.Close()
+	gw.pool.Put(gw.gz)
+	gw.gz = nil
+}
+
+func (gw *gzipWriter) Unwrap() http.ResponseWriter {
+	return gw.ResponseWriter
+}
+
+// GzipMiddleware compresses responses at level for clients accepting gzip.
+// Writers are pooled, as each one allocates its compression state up front.
+// A level of 0 disables it.
+func GzipMiddleware(level int) mux.MiddlewareFunc {
+	pool := &sync.Pool{
+		New: func() interface{} {
+			gz, _ := gzip.NewWriterLevel(nil, level)
+			return gz
+		},
+	}
+
+	return func(next http.Handler) http.Handler {
+		if level == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !acceptsGzip(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			gw := &gzipWriter{ResponseWriter: w, pool: pool}
+			defer gw.close()
+			next.ServeHTTP(gw, r)
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+var gzipBody = bytes.Repeat([]byte(`{"id":1,"name":"item","tags":["a","b"]},`), 200)
+
+func gzipHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(gzipBody)
+}
+
+// unpooledGzip is GzipMiddleware with a writer allocated for each request,
+// kept to compare against
+func unpooledGzip(level int) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			pool := &sync.Pool{New: func() interface{} {
+				gz, _ := gzip.NewWriterLevel(nil, level)
+				return gz
+			}}
+			gw := &gzipWriter{ResponseWriter: w, pool: pool}
+			defer gw.close()
+			next.ServeHTTP(gw, r)
+		})
+	}
+}
+
+func gzipRequest() *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/items", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	return r
+}
+
+func TestGzipMiddleware(t *testing.T) {
+	handler := GzipMiddleware(gzip.BestSpeed)(http.HandlerFunc(gzipHandler))
+	// Twice, the second time with a writer from the pool
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, gzipRequest())
+
+		if w.Header().Get("Content-Encoding") != "gzip" {
+			t.Fatalf("got Content-Encoding %q, want gzip", w.Header().Get("Content-Encoding"))
+		}
+		gz, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(gz)
+		if err != nil || !bytes.Equal(body, gzipBody) {
+			t.Fatalf("got %d bytes (%v), want the %d of the body", len(body), err, len(gzipBody))
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/items", nil))
+	if w.Header().Get("Content-Encoding") != "" || !bytes.Equal(w.Body.Bytes(), gzipBody) {
+		t.Errorf("compressed for a client not accepting gzip")
+	}
+}
+
+func BenchmarkGzip(b *testing.B) {
+	for _, bench := range []struct {
+		name       string
+		middleware mux.MiddlewareFunc
+	}{
+		{"pooled", GzipMiddleware(gzip.DefaultCompression)},
+		{"unpooled", unpooledGzip(gzip.DefaultCompression)},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			handler := bench.middleware(http.HandlerFunc(gzipHandler))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				handler.ServeHTTP(httptest.NewRecorder(), gzipRequest())
+			}
+		})
+	}
+}
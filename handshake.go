@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// handshakeTiming is when a connection's TLS handshake started and ended,
+// as unix nanoseconds
+type handshakeTiming struct {
+	start    atomic.Int64
+	end      atomic.Int64
+	reported atomic.Bool
+}
+
+// handshakes maps the connections in their handshake to their timing, by
+// the raw connection under the *tls.Conn, which is all GetConfigForClient
+// is handed
+var handshakes sync.Map
+
+// TimeHandshakes has srv time every TLS handshake, from the ClientHello to
+// the connection being verified, for TLSHandshakeFromContext. It chains the
+// server's ConnContext and ConnState hooks and sets its config's
+// GetConfigForClient, which must be free.
+func TimeHandshakes(srv *http.Server) {
+	base := srv.TLSConfig
+	// The configs handed out are cloned from base, not from the one ServeTLS
+	// derives from it, so offering HTTP/2 is up to base
+	if len(base.NextProtos) == 0 {
+		base.NextProtos = []string{"h2", "http/1.1"}
+	}
+
+	base.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		v, ok := handshakes.Load(hello.Conn)
+		if !ok {
+			return nil, nil
+		}
+		t := v.(*handshakeTiming)
+		t.start.Store(time.Now().UnixNano())
+
+		cfg := base.Clone()
+		cfg.GetConfigForClient = nil
+		cfg.VerifyConnection = func(tls.ConnectionState) error {
+			t.end.Store(time.Now().UnixNano())
+			return nil
+		}
+		return cfg, nil
+	}
+
+	connContext := srv.ConnContext
+	srv.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+		if connContext != nil {
+			ctx = connContext(ctx, c)
+		}
+		if tc, ok := c.(*tls.Conn); ok {
+			t := &handshakeTiming{}
+			handshakes.Store(tc.NetConn(), t)
+			ctx = context.WithValue(ctx, handshakeKey, t)
+		}
+		return ctx
+	}
+
+	connState := srv.ConnState
+	srv.ConnState = func(c net.Conn, state http.ConnState) {
+		if connState != nil {
+			connState(c, state)
+		}
+		if tc, ok := c.(*tls.Conn); ok && (state == http.StateClosed || state == http.StateHijacked) {
+			handshakes.Delete(tc.NetConn())
+		}
+	}
+}
+
+// TLSHandshakeFromContext is how long the TLS handshake of the request's
+// connection took, and false over plain HTTP, when it wasn't timed, or for
+// every request but the first on the connection, which the handshake
+// happened for
+func TLSHandshakeFromContext(r *http.Request) (time.Duration, bool) {
+	t, ok := r.Context().Value(handshakeKey).(*handshakeTiming)
+	if !ok {
+		return 0, false
+	}
+	start, end := t.start.Load(), t.end.Load()
+	if start == 0 || end == 0 || !t.reported.CompareAndSwap(false, true) {
+		return 0, false
+	}
+	return time.Duration(end - start), true
+}
@@ -0,0 +1,16 @@
+package main
+
+import "net/http"
+
+const HealthPath string = "/healthz"
+
+// IsHealthCheck tells the requests that keep being answered whatever
+// middlewares shedding or refusing traffic decide
+func IsHealthCheck(r *http.Request) bool {
+	return r.URL.Path == HealthPath || r.URL.Path == ReadyPath
+}
+
+func HealthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok"))
+}
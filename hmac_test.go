@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHMACVerifyMiddleware(t *testing.T) {
+	captureStdLog(t)
+
+	secret := []byte("webhook secret")
+	body := `{"event":"push"}`
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(body))
+	sum := mac.Sum(nil)
+
+	tests := []struct {
+		name      string
+		encoding  string
+		body      string
+		signature string
+		want      int
+	}{
+		{"valid hex", HMACHex, body, hex.EncodeToString(sum), http.StatusOK},
+		{"valid prefixed", HMACHex, body, "sha256=" + hex.EncodeToString(sum), http.StatusOK},
+		{"valid base64", HMACBase64, body, base64.StdEncoding.EncodeToString(sum), http.StatusOK},
+		{"tampered body", HMACHex, `{"event":"pull"}`, hex.EncodeToString(sum), http.StatusUnauthorized},
+		{"tampered signature", HMACHex, body, "00" + hex.EncodeToString(sum)[2:], http.StatusUnauthorized},
+		{"wrong length", HMACHex, body, hex.EncodeToString(sum[:16]), http.StatusUnauthorized},
+		{"not hex", HMACHex, body, "zz" + hex.EncodeToString(sum)[2:], http.StatusUnauthorized},
+		{"wrong encoding", HMACBase64, body, hex.EncodeToString(sum), http.StatusUnauthorized},
+		{"missing header", HMACHex, body, "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			var signed bool
+			handler := HMACVerifyMiddleware(secret, "X-Signature", tt.encoding)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := io.ReadAll(r.Body)
+				got, signed = string(b), SignedFromContext(r)
+			}))
+
+			r := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(tt.body))
+			if tt.signature != "" {
+				r.Header.Set("X-Signature", tt.signature)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Fatalf("got status %d, want %d", w.Code, tt.want)
+			}
+			if tt.want == http.StatusOK && (got != tt.body || !signed) {
+				t.Errorf("handler got body %q, signed %v, want %q signed", got, signed, tt.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// connectionTokens are the extra hop-by-hop headers named by Connection
+func connectionTokens(h http.Header) []string {
+	tokens := []string{}
+	for _, value := range h.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if token = strings.TrimSpace(token); token != "" {
+				tokens = append(tokens, token)
+			}
+		}
+	}
+	return tokens
+}
+
+// hasToken tells whether tokens hold token, case insensitively
+func hasToken(tokens []string, token string) bool {
+	for _, t := range tokens {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// StripHopByHop removes the hop-by-hop headers from h, the standard ones and
+// those its Connection header lists, as for a request, or a proxied response
+// before it is copied into this server's own
+func StripHopByHop(h http.Header) {
+	for _, name := range connectionTokens(h) {
+		h.Del(name)
+	}
+	for _, name := range hopByHopHeaders {
+		h.Del(name)
+	}
+}
+
+// stripResponseHopByHop is StripHopByHop for this server's own responses,
+// also removing the headers the request's Connection lists. They keep the
+// Trailer announcing their trailers and a Connection: close, which net/http
+// acts on by closing the connection.
+func stripResponseHopByHop(h http.Header, requestTokens []string) {
+	closing := hasToken(connectionTokens(h), "close")
+	trailer := h.Values("Trailer")
+
+	StripHopByHop(h)
+	for _, name := range requestTokens {
+		h.Del(name)
+	}
+
+	if closing {
+		h.Set("Connection", "close")
+	}
+	if len(trailer) > 0 {
+		h["Trailer"] = trailer
+	}
+}
+
+// HopByHopMiddleware strips hop-by-hop headers from requests before the
+// handler sees them, and from responses before they are sent, the standard
+// ones and those the request's or response's Connection header lists.
+// Responses keep their Trailer and Connection: close, and protocol switches,
+// both ways, keep everything.
+func HopByHopMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writer, ok := w.(*ResponseRecorderWriter)
+		if !ok {
+			writer = &ResponseRecorderWriter{ResponseWriter: w, Status: http.StatusOK}
+		}
+
+		requestTokens := connectionTokens(r.Header)
+		if !hasToken(requestTokens, "upgrade") {
+			header := r.Header.Clone()
+			StripHopByHop(header)
+			r = r.WithContext(r.Context())
+			r.Header = header
+		}
+
+		writer.OnWriteHeader(func(status int, header http.Header) {
+			if status == http.StatusSwitchingProtocols {
+				return
+			}
+			stripResponseHopByHop(header, requestTokens)
+		})
+
+		next.ServeHTTP(writer, r)
+	})
+}
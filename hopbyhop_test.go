@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHopByHopResponse(t *testing.T) {
+	handler := HopByHopMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("Keep-Alive", "timeout=5")
+		h.Set("X-Hop", "1")
+		h.Set("X-Secret", "1")
+		h.Set("Connection", "X-Hop, close")
+		h.Set("Trailer", "X-Checksum")
+		w.Write([]byte("body"))
+		h.Set("X-Checksum", "abc")
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Connection", "X-Secret, Trailer")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	res := w.Result()
+	for _, name := range []string{"Keep-Alive", "X-Hop", "X-Secret"} {
+		if v := res.Header.Get(name); v != "" {
+			t.Errorf("%s: %q not stripped", name, v)
+		}
+	}
+	if v := res.Header.Get("Connection"); v != "close" {
+		t.Errorf("got Connection %q, want close kept", v)
+	}
+	if v := res.Header.Get("Trailer"); v != "X-Checksum" {
+		t.Errorf("got Trailer %q, want X-Checksum kept", v)
+	}
+	if v := res.Trailer.Get("X-Checksum"); v != "abc" {
+		t.Errorf("got X-Checksum trailer %q, want abc", v)
+	}
+}
+
+func TestHopByHopRequest(t *testing.T) {
+	var seen http.Header
+	handler := HopByHopMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Connection", "X-Secret, close")
+	r.Header.Set("X-Secret", "1")
+	r.Header.Set("Keep-Alive", "300")
+	r.Header.Set("Te", "trailers")
+	r.Header.Set("Trailer", "X-Checksum")
+	r.Header.Set("Accept", "text/plain")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	for _, name := range []string{"Connection", "X-Secret", "Keep-Alive", "Te", "Trailer"} {
+		if v := seen.Get(name); v != "" {
+			t.Errorf("handler saw %s: %q", name, v)
+		}
+	}
+	if seen.Get("Accept") != "text/plain" {
+		t.Error("handler didn't see Accept")
+	}
+	if r.Header.Get("X-Secret") != "1" {
+		t.Error("caller's request headers modified")
+	}
+}
+
+func TestHopByHopUpgrade(t *testing.T) {
+	var seen http.Header
+	handler := HopByHopMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header
+		w.Header().Set("Connection", "Upgrade")
+		w.Header().Set("Upgrade", "websocket")
+		w.WriteHeader(http.StatusSwitchingProtocols)
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if seen.Get("Upgrade") != "websocket" || seen.Get("Connection") != "Upgrade" {
+		t.Errorf("handler saw %v, want the upgrade headers", seen)
+	}
+	if w.Header().Get("Upgrade") != "websocket" || w.Header().Get("Connection") != "Upgrade" {
+		t.Errorf("got response headers %v, want the upgrade ones", w.Header())
+	}
+}
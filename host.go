@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// hostOnly strips the port, and the brackets of IPv6 literals, from a Host
+// header and lowercases it
+func hostOnly(hostport string) string {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = strings.TrimSuffix(strings.TrimPrefix(hostport, "["), "]")
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// MatchHost reports whether host is pattern, or a subdomain of it when
+// pattern is like *.example.com. Ports are ignored on both sides.
+func MatchHost(pattern, host string) bool {
+	pattern, host = hostOnly(pattern), hostOnly(host)
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return strings.HasSuffix(host, "."+suffix)
+	}
+	return host == pattern
+}
+
+// HostAllowlistMiddleware answers a 400 to requests whose Host isn't one of
+// hosts, health checks aside. No hosts allows any.
+func HostAllowlistMiddleware(hosts ...string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		if len(hosts) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if IsHealthCheck(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			for _, pattern := range hosts {
+				if MatchHost(pattern, r.Host) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			Reject(w, r, http.StatusBadRequest, "host not allowed: "+strconv.Quote(r.Host))
+		})
+	}
+}
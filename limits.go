@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// headerFields counts the header lines of h, a name sent on several lines
+// counting each of them
+func headerFields(h http.Header) int {
+	n := 0
+	for _, values := range h {
+		n += len(values)
+	}
+	return n
+}
+
+// RequestLimitsMiddleware refuses requests with more than maxHeaders header
+// fields or an URL longer than maxURLLength, zero disabling either check.
+func RequestLimitsMiddleware(maxHeaders, maxURLLength int) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if n := headerFields(r.Header); maxHeaders > 0 && n > maxHeaders {
+				Reject(
+					w, r, http.StatusRequestHeaderFieldsTooLarge,
+					fmt.Sprintf("%d header fields, more than %d", n, maxHeaders),
+				)
+				return
+			}
+			if n := len(r.URL.String()); maxURLLength > 0 && n > maxURLLength {
+				Reject(
+					w, r, http.StatusRequestURITooLong,
+					fmt.Sprintf("URL of %d bytes, longer than %d", n, maxURLLength),
+				)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// MethodBlocklistMiddleware answers a 405 to methods, like TRACE and CONNECT
+// sent by scanners, before routing. Methods compare case insensitively. It
+// goes inside MethodOverrideMiddleware, which would otherwise let a POST
+// through as a blocked method.
+func MethodBlocklistMiddleware(methods ...string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		if len(methods) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, method := range methods {
+				if strings.EqualFold(r.Method, method) {
+					Reject(w, r, http.StatusMethodNotAllowed, "blocked method "+strconv.Quote(r.Method))
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestLimitsHeaderFields(t *testing.T) {
+	captureLog(t, accessLog)
+	handler := RequestLimitsMiddleware(3, 0)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   int
+	}{
+		{"under", http.Header{"A": {"1"}, "B": {"1"}}, http.StatusOK},
+		{"at", http.Header{"A": {"1"}, "B": {"1"}, "C": {"1"}}, http.StatusOK},
+		{"repeated name", http.Header{"A": {"1", "2", "3", "4"}}, http.StatusRequestHeaderFieldsTooLarge},
+		{"mixed", http.Header{"A": {"1", "2"}, "B": {"1", "2"}}, http.StatusRequestHeaderFieldsTooLarge},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header = tt.header
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
+
+func TestMethodBlocklistOverride(t *testing.T) {
+	captureLog(t, accessLog)
+	captureStdLog(t)
+
+	var routed string
+	// As main wraps them
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		routed = r.Method
+	})
+	handler = MethodBlocklistMiddleware("TRACE", "DELETE")(handler)
+	handler = MethodOverrideMiddleware("PUT", "DELETE")(handler)
+
+	tests := []struct {
+		method, override string
+		status           int
+		routed           string
+	}{
+		{http.MethodPost, "PUT", http.StatusOK, http.MethodPut},
+		{http.MethodPost, "delete", http.StatusMethodNotAllowed, ""},
+		{http.MethodDelete, "", http.StatusMethodNotAllowed, ""},
+		{http.MethodTrace, "", http.StatusMethodNotAllowed, ""},
+		// Not an allowed override, routed as the POST it is
+		{http.MethodPost, "TRACE", http.StatusOK, http.MethodPost},
+	}
+	for _, tt := range tests {
+		routed = ""
+		r := httptest.NewRequest(tt.method, "/items", nil)
+		if tt.override != "" {
+			r.Header.Set(MethodOverrideHeader, tt.override)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tt.status || routed != tt.routed {
+			t.Errorf("%s as %q: got %d routed as %q, want %d routed as %q",
+				tt.method, tt.override, w.Code, routed, tt.status, tt.routed)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// BusyPage is what reads get while the request budget is spent, loaded once
+// so serving it costs next to nothing when the server can least afford it
+type BusyPage struct {
+	content     []byte
+	contentType string
+}
+
+var defaultBusyPage *BusyPage = &BusyPage{
+	content:     []byte("We're busy right now, please try again in a moment.\n"),
+	contentType: "text/plain; charset=utf-8",
+}
+
+// LoadBusyPage reads the page at path, the default plain text one when
+// path is empty
+func LoadBusyPage(path string) (*BusyPage, error) {
+	if path == "" {
+		return defaultBusyPage, nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("busy page: %w", err)
+	}
+	contentType := mime.TypeByExtension(filepath.Ext(path))
+	if contentType == "" {
+		contentType = http.DetectContentType(content)
+	}
+	return &BusyPage{content: content, contentType: contentType}, nil
+}
+
+// LoadShedMiddleware lets at most budget requests in at once, in flight or
+// queued for a concurrency slot, health checks aside. Past it, GET and HEAD
+// requests get the busy page and every other one a plain 503, both with a
+// Retry-After of retryAfter, and nothing reaches the handlers. A budget of
+// 0 disables it.
+func LoadShedMiddleware(budget int, page *BusyPage, retryAfter time.Duration) mux.MiddlewareFunc {
+	var inside atomic.Int64
+
+	return func(next http.Handler) http.Handler {
+		if budget <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if IsHealthCheck(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			n := inside.Add(1)
+			defer inside.Add(-1)
+			if n <= int64(budget) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			reason := "over the request budget of " + strconv.Itoa(budget)
+			SetRetryAfter(w.Header(), retryAfter)
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				requestsShed.Inc("write")
+				Reject(w, r, http.StatusServiceUnavailable, reason)
+				return
+			}
+
+			requestsShed.Inc("read")
+			LogRejection(r, r.URL.Path, http.StatusServiceUnavailable, reason+", served the busy page")
+			w.Header().Set("Content-Type", page.contentType)
+			w.Header().Set("Content-Length", strconv.Itoa(len(page.content)))
+			w.Header().Set("Cache-Control", "no-store")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			if r.Method != http.MethodHead {
+				w.Write(page.content)
+			}
+		})
+	}
+}
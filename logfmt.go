@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// logfmtValue quotes values that would otherwise not read back as a single
+// value: empty ones, and those holding spaces, quotes, equal signs or
+// anything unprintable, like control characters sent in a path
+func logfmtValue(value interface{}) string {
+	var v string
+	switch value := value.(type) {
+	case []panicCause:
+		// The messages repeat each other down the chain, the types tell it
+		types := make([]string, len(value))
+		for i, cause := range value {
+			types[i] = cause.Type
+		}
+		v = strings.Join(types, ",")
+	default:
+		v = fmt.Sprint(value)
+	}
+
+	if v == "" || strings.IndexFunc(v, func(r rune) bool {
+		return r == ' ' || r == '"' || r == '=' || r == '\\' || !unicode.IsPrint(r)
+	}) >= 0 {
+		return strconv.Quote(v)
+	}
+	return v
+}
+
+// logfmtString lays out pairs of keys and values as key=value
+func logfmtString(pairs ...interface{}) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(&b, "%s=%s", pairs[i], logfmtValue(pairs[i+1]))
+	}
+	return b.String()
+}
+
+// LogfmtString logs the same fields as JSONString as key=value pairs, with
+// durations kept readable, like duration=1.2ms
+func (rl RequestLogger) LogfmtString() string {
+	fields := rl.fields()
+	pairs := make([]interface{}, 0, 2*len(fields))
+	for _, f := range fields {
+		pairs = append(pairs, f.Key, f.Value)
+	}
+	return logfmtString(pairs...)
+}
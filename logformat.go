@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+type LogFormat string
+
+const (
+	LogFormatDefault  LogFormat = "default"
+	LogFormatExtended LogFormat = "extended"
+	LogFormatJSON     LogFormat = "json"
+	LogFormatCommon   LogFormat = "common"
+	LogFormatLogfmt   LogFormat = "logfmt"
+)
+
+// How the text formats log control characters, like the ANSI escapes of a
+// crafted path that would otherwise recolor or rewrite the terminal
+const (
+	ControlCharsEscape string = "escape"
+	ControlCharsStrip  string = "strip"
+)
+
+var (
+	logExclusions LogExclusions
+	logTheme      *LogTheme = &LogTheme{Separator: "|"}
+	controlChars  string    = ControlCharsEscape
+	panicStacks   bool
+)
+
+func ValidateControlChars(mode string) error {
+	if mode != ControlCharsEscape && mode != ControlCharsStrip {
+		return fmt.Errorf("unknown control characters handling %q, expected escape or strip", mode)
+	}
+	return nil
+}
+
+// sanitize escapes control characters as Go does, \x1b for ESC, or strips
+// them, so logged values can't mess with the colors around them
+func sanitize(v string) string {
+	if strings.IndexFunc(v, unicode.IsControl) < 0 {
+		return v
+	}
+	var b strings.Builder
+	for _, r := range v {
+		switch {
+		case !unicode.IsControl(r):
+			b.WriteRune(r)
+		case controlChars == ControlCharsEscape:
+			q := strconv.QuoteRune(r)
+			b.WriteString(q[1 : len(q)-1])
+		}
+	}
+	return b.String()
+}
+
+// LogTheme styles the column separators of the text formats
+type LogTheme struct {
+	Separator      string `json:"separator"`
+	SeparatorColor string `json:"separator_color"`
+}
+
+func (t *LogTheme) Sep() string {
+	if t.SeparatorColor == "" {
+		return t.Separator
+	}
+	return t.SeparatorColor + t.Separator + colors.Reset
+}
+
+// ColorByName resolves a color name, or "" for none, to its ANSI code. Raw
+// escape sequences are passed through.
+func ColorByName(name string) (string, error) {
+	switch strings.ToLower(name) {
+	case "", "none":
+		return "", nil
+	case "red":
+		return colors.Red, nil
+	case "green":
+		return colors.Green, nil
+	case "yellow":
+		return colors.Yellow, nil
+	case "blue":
+		return colors.Blue, nil
+	case "magenta":
+		return colors.Magenta, nil
+	case "cyan":
+		return colors.Cyan, nil
+	case "dim":
+		return colors.Dim, nil
+	}
+	if strings.HasPrefix(name, "\033[") {
+		return name, nil
+	}
+	return "", fmt.Errorf("unknown color %q", name)
+}
+
+// LogExclusions lists path prefixes left out of the access log, like health
+// checks and metrics scrapes. With LogErrors they are still logged when the
+// response isn't a 2xx.
+type LogExclusions struct {
+	Prefixes  []string `json:"prefixes"`
+	LogErrors bool     `json:"log_errors"`
+}
+
+func (e LogExclusions) Skip(path string, status int) bool {
+	if e.LogErrors && (status < 200 || status >= 300) {
+		return false
+	}
+	for _, prefix := range e.Prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *LogFormat) Set(v string) error {
+	if err := LogFormat(v).Validate(); err != nil {
+		return err
+	}
+	*f = LogFormat(v)
+	return nil
+}
+
+func (f *LogFormat) String() string { return string(*f) }
+
+func (f LogFormat) Validate() error {
+	switch f {
+	case LogFormatDefault, LogFormatExtended, LogFormatJSON, LogFormatCommon, LogFormatLogfmt:
+		return nil
+	}
+	return fmt.Errorf("unknown log format %q", string(f))
+}
+
+type logField struct {
+	Key   string
+	Value interface{}
+}
+
+// extendedFields are the optional fields appended by the extended format,
+// skipping the ones that were never set.
+func (rl RequestLogger) extendedFields() []logField {
+	fields := []logField{}
+	add := func(key string, value string) {
+		if value != "" {
+			fields = append(fields, logField{key, value})
+		}
+	}
+
+	add("client_ip", rl.GetClientIP())
+	add("proto", rl.GetProto())
+	add("host", rl.GetHost())
+	add("sni", rl.GetServerName())
+	// Certificates are picked by SNI, routing by Host, a client sending
+	// different ones may get a certificate not matching the site it sees
+	if sni := rl.GetServerName(); sni != "" && sni != "-" && rl.GetHost() != "" &&
+		!strings.EqualFold(sni, hostOnly(rl.GetHost())) {
+		fields = append(fields, logField{"sni_mismatch", true})
+	}
+	add("served_by", rl.GetServedBy())
+	add("tls", rl.GetTLSVersion())
+	add("cipher", rl.GetTLSCipher())
+	// Per connection, so only on its first request, later ones reuse it
+	if d, ok := rl.GetTLSHandshake(); ok {
+		fields = append(fields, logField{"tls_handshake", d})
+	}
+	add("content_type", rl.GetContentType())
+	add("country", rl.GetCountry())
+	add("region", rl.GetRegion())
+	fields = append(fields, rl.GetBaggage().logFields()...)
+
+	if rl.GetAPIVersion() > 0 {
+		fields = append(fields, logField{"api_version", rl.GetAPIVersion()})
+	}
+	if rl.GetResponseBytes() > 0 {
+		fields = append(fields, logField{"response_bytes", rl.GetResponseBytes()})
+	}
+	if n, ok := rl.GetRequestBytes(); ok {
+		fields = append(fields, logField{"request_bytes", n})
+	}
+	if waited, ok := rl.GetQueueTime(); ok {
+		fields = append(fields, logField{"queue_time", waited})
+	}
+	if rl.GetTTFB() > 0 {
+		fields = append(fields, logField{"ttfb", rl.GetTTFB()})
+	}
+	if rl.GetCancelled() {
+		fields = append(fields, logField{"client_cancelled", true})
+	}
+	add("write_error", rl.GetWriteError())
+
+	return fields
+}
+
+func (rl RequestLogger) ExtendedString() string {
+	var b strings.Builder
+	b.WriteString(rl.String())
+
+	fields := rl.extendedFields()
+	if len(fields) > 0 {
+		b.WriteString(" " + logTheme.Sep())
+	}
+	for _, f := range fields {
+		v := sanitize(fmt.Sprint(f.Value))
+		if strings.ContainsAny(v, " \"") {
+			v = strconv.Quote(v)
+		}
+		fmt.Fprintf(&b, " %s=%s", f.Key, v)
+	}
+	return b.String()
+}
+
+// fields are all the fields of the structured formats. The route template
+// is only logged by them, the text formats already show the path.
+func (rl RequestLogger) fields() []logField {
+	fields := []logField{
+		{"id", rl.GetRequestID()},
+		{"method", rl.GetMethod()},
+		{"status", rl.GetStatus()},
+		{"duration", rl.GetSince()},
+		{"path", rl.GetPath()},
+	}
+	if rl.GetRoute() != "" {
+		fields = append(fields, logField{"route", rl.GetRoute()})
+	}
+	fields = append(fields, rl.extendedFields()...)
+	if rl.GetMessage() != "" {
+		fields = append(fields, logField{"message", rl.GetMessage()})
+	}
+	if rl.GetPanicType() != "" {
+		fields = append(fields, logField{"panic_type", rl.GetPanicType()})
+	}
+	if chain := rl.GetPanicChain(); len(chain) > 0 {
+		fields = append(fields, logField{"panic_chain", chain})
+	}
+	if rl.GetPanicStack() != "" {
+		fields = append(fields, logField{"panic_stack", rl.GetPanicStack()})
+	}
+	return fields
+}
+
+// JSONString logs every field as a single JSON object, with durations in
+// milliseconds under a _ms suffixed key.
+func (rl RequestLogger) JSONString() string {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, f := range rl.fields() {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, value := f.Key, f.Value
+		if d, ok := value.(time.Duration); ok {
+			key, value = key+"_ms", float64(d)/float64(time.Millisecond)
+		}
+		k, _ := json.Marshal(key)
+		v, err := json.Marshal(value)
+		if err != nil {
+			v, _ = json.Marshal(fmt.Sprint(value))
+		}
+		b.Write(k)
+		b.WriteByte(':')
+		b.Write(v)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func (rl RequestLogger) Format(format LogFormat) string {
+	switch format {
+	case LogFormatExtended:
+		if rl.GetPanicType() != "" {
+			return rl.PanicString()
+		}
+		if rl.GetMessage() != "" {
+			return rl.MessageString(rl.GetMessage())
+		}
+		return rl.ExtendedString()
+	case LogFormatJSON:
+		return rl.JSONString()
+	case LogFormatCommon:
+		return rl.CommonString()
+	case LogFormatLogfmt:
+		return rl.LogfmtString()
+	}
+	if rl.GetPanicType() != "" {
+		return rl.PanicString()
+	}
+	if rl.GetMessage() != "" {
+		return rl.MessageString(rl.GetMessage())
+	}
+	return rl.String()
+}
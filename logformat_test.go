@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestSanitize(t *testing.T) {
+	saved := controlChars
+	t.Cleanup(func() { controlChars = saved })
+
+	tests := []struct {
+		in, escaped, stripped string
+	}{
+		{"/items", "/items", "/items"},
+		{"/\033[31mred\033[0m", `/\x1b[31mred\x1b[0m`, "/[31mred[0m"},
+		{"a\r\nb\tc", `a\r\nb\tc`, "abc"},
+		{"nul\x00del\x7f", `nul\x00del\x7f`, "nuldel"},
+		{"c1\u009bx", `c1\u009bx`, "c1x"},
+		{"héllo ✓", "héllo ✓", "héllo ✓"},
+	}
+	for _, tt := range tests {
+		controlChars = ControlCharsEscape
+		if got := sanitize(tt.in); got != tt.escaped {
+			t.Errorf("escape %q: got %q, want %q", tt.in, got, tt.escaped)
+		}
+		controlChars = ControlCharsStrip
+		if got := sanitize(tt.in); got != tt.stripped {
+			t.Errorf("strip %q: got %q, want %q", tt.in, got, tt.stripped)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"math"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type LogLevel int32
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+func (l LogLevel) String() string {
+	if l < 0 || int(l) >= len(logLevelNames) {
+		return fmt.Sprintf("level(%d)", int32(l))
+	}
+	return logLevelNames[l]
+}
+
+func (l LogLevel) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+func (l *LogLevel) UnmarshalText(text []byte) error {
+	for i, name := range logLevelNames {
+		if strings.EqualFold(string(text), name) {
+			*l = LogLevel(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown log level %q", string(text))
+}
+
+func (l *LogLevel) Set(v string) error { return l.UnmarshalText([]byte(v)) }
+
+// StatusLevel is the level access log lines are logged at
+func StatusLevel(status int) LogLevel {
+	switch {
+	case status >= 500:
+		return LevelError
+	case status >= 400:
+		return LevelWarn
+	default:
+		return LevelInfo
+	}
+}
+
+type formatter interface {
+	Format(format LogFormat) string
+}
+
+// Logger writes entries at or above its level in its format, timestamped
+// at its precision. All three can be swapped while serving.
+type Logger struct {
+	logger    *log.Logger
+	level     atomic.Int32
+	format    atomic.Value
+	precision atomic.Int64
+
+	// repeats, when set, holds back repeated access lines
+	repeats *repeats
+	// slog, when set, gets the entries as structured records instead
+	slog atomic.Pointer[slog.Logger]
+}
+
+func NewLogger(w io.Writer, level LogLevel, format LogFormat) *Logger {
+	l := &Logger{logger: log.New(w, "", 0)}
+	l.SetLevel(level)
+	l.SetFormat(format)
+	l.SetPrecision(time.Second)
+	return l
+}
+
+// ParseTimePrecision reads s, ms or us as the precision of log timestamps
+func ParseTimePrecision(precision string) (time.Duration, error) {
+	switch precision {
+	case "s":
+		return time.Second, nil
+	case "ms":
+		return time.Millisecond, nil
+	case "us":
+		return time.Microsecond, nil
+	}
+	return 0, fmt.Errorf("unknown log time precision %q", precision)
+}
+
+// Access lines and handled errors (panics, handler logs) go to separate
+// loggers, so they can be routed to different places.
+var (
+	accessLog *Logger = NewLogger(os.Stderr, LevelInfo, LogFormatDefault)
+	errorLog  *Logger = NewLogger(os.Stderr, LevelInfo, LogFormatDefault)
+)
+
+func (l *Logger) SetLevel(level LogLevel) {
+	l.level.Store(int32(level))
+}
+
+func (l *Logger) Level() LogLevel {
+	return LogLevel(l.level.Load())
+}
+
+func (l *Logger) SetFormat(format LogFormat) {
+	l.format.Store(format)
+}
+
+func (l *Logger) Format() LogFormat {
+	return l.format.Load().(LogFormat)
+}
+
+func (l *Logger) SetPrecision(precision time.Duration) {
+	l.precision.Store(int64(precision))
+}
+
+func (l *Logger) Precision() time.Duration {
+	return time.Duration(l.precision.Load())
+}
+
+func (l *Logger) Writer() io.Writer {
+	return l.logger.Writer()
+}
+
+// SetOutput redirects the logger, for instance into a buffer to assert on
+// its lines
+func (l *Logger) SetOutput(w io.Writer) {
+	l.logger.SetOutput(w)
+}
+
+func (l *Logger) Enabled(level LogLevel) bool {
+	return level >= l.Level()
+}
+
+// Print timestamps entries itself, as the log flags stop at microseconds
+// and can't give milliseconds. At second precision lines keep the log
+// package's layout, finer ones add the fraction and JSON entries get an RFC
+// 3339 time field instead of a prefix. logfmt lines always lead with one,
+// and Common Log Format lines carry their own date.
+func (l *Logger) Print(level LogLevel, entry formatter) {
+	if !l.Enabled(level) {
+		return
+	}
+	if rl, ok := entry.(*RequestLogger); ok && l.repeats != nil && l.repeats.hold(level, rl) {
+		return
+	}
+	if s := l.slog.Load(); s != nil {
+		printSlog(s, level, entry)
+		return
+	}
+
+	var (
+		format    LogFormat     = l.Format()
+		line      string        = entry.Format(format)
+		precision time.Duration = l.Precision()
+		now       time.Time     = time.Now().Truncate(precision)
+	)
+
+	switch {
+	case format == LogFormatCommon:
+		if _, ok := entry.(*RequestLogger); !ok {
+			line = now.Format("2006/01/02 15:04:05") + " " + line
+		}
+	case format == LogFormatLogfmt:
+		line = "time=" + now.Format(time.RFC3339Nano) + " " + line
+	case precision >= time.Second:
+		line = now.Format("2006/01/02 15:04:05") + " " + line
+	case format == LogFormatJSON && strings.HasPrefix(line, "{"):
+		field := `"time":"` + now.Format(time.RFC3339Nano) + `"`
+		if line != "{}" {
+			field += ","
+		}
+		line = "{" + field + line[1:]
+	default:
+		digits := strings.Repeat("0", int(math.Round(math.Log10(float64(time.Second/precision)))))
+		line = now.Format("2006/01/02 15:04:05."+digits) + " " + line
+	}
+
+	l.logger.Println(line)
+}
+
+// OpenLogDestination opens stderr, stdout or a file path for appending
+func OpenLogDestination(destination string) (io.Writer, error) {
+	switch destination {
+	case "", "stderr":
+		return os.Stderr, nil
+	case "stdout":
+		return os.Stdout, nil
+	}
+	f, err := os.OpenFile(destination, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("log: %w", err)
+	}
+	return f, nil
+}
+
+// SetupLoggers points the access and error loggers at their configured
+// destinations, through background writers with ASYNC_LOG_BUFFER, the access
+// log collapsing repeated lines with LOG_COLLAPSE_WINDOW.
+// Everything else logged through the standard log package, like the server's
+// own errors, goes to the error log destination.
+func SetupLoggers(cfg *Config) error {
+	access, err := OpenLogDestination(cfg.AccessLog)
+	if err != nil {
+		return err
+	}
+	errs, err := OpenLogDestination(cfg.ErrorLog)
+	if err != nil {
+		return err
+	}
+
+	var asyncAccess, asyncErrors *AsyncWriter
+	if cfg.AsyncLogBuffer > 0 {
+		asyncAccess = NewAsyncWriter(access, cfg.AsyncLogBuffer, "access")
+		asyncErrors = NewAsyncWriter(errs, cfg.AsyncLogBuffer, "error")
+		access, errs = asyncAccess, asyncErrors
+	}
+
+	separatorColor, err := ColorByName(cfg.LogSeparatorColor)
+	if err != nil {
+		return err
+	}
+	logTheme = &LogTheme{Separator: cfg.LogSeparator, SeparatorColor: separatorColor}
+	controlChars = cfg.LogControlChars
+	panicStacks = cfg.PanicStack
+
+	precision, err := ParseTimePrecision(cfg.LogTimePrecision)
+	if err != nil {
+		return err
+	}
+
+	accessLog = NewLogger(access, cfg.AccessLogLevel, cfg.LogFormat)
+	accessLog.SetPrecision(precision)
+	accessLog.CollapseRepeats(time.Duration(cfg.LogCollapseWindow))
+	errorLog = NewLogger(errs, cfg.ErrorLogLevel, cfg.ErrorLogFormat)
+	errorLog.SetPrecision(precision)
+	if cfg.LogBackend == LogBackendSlog {
+		// Levels are already filtered by the loggers
+		options := &slog.HandlerOptions{Level: slog.LevelDebug}
+		accessLog.SetSlog(slog.New(slog.NewJSONHandler(access, options)).With("log", "access"))
+		errorLog.SetSlog(slog.New(slog.NewJSONHandler(errs, options)).With("log", "error"))
+	}
+	log.SetOutput(errs)
+
+	// The held back repeats go out before the background writers are
+	// flushed
+	OnShutdown("logs", func(ctx context.Context) error {
+		accessLog.repeats.Flush()
+		if asyncAccess == nil {
+			return nil
+		}
+		if err := asyncAccess.Flush(ctx); err != nil {
+			return err
+		}
+		if err := asyncErrors.Flush(ctx); err != nil {
+			return err
+		}
+		if a, e := asyncAccess.Dropped(), asyncErrors.Dropped(); a+e > 0 {
+			log.Printf("| Dropped %d access and %d error log lines", a, e)
+		}
+		return nil
+	})
+
+	return nil
+}
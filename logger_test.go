@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// capturedLog holds what a logger wrote during a test
+type capturedLog struct {
+	buf bytes.Buffer
+}
+
+func (c *capturedLog) String() string {
+	return c.buf.String()
+}
+
+// Lines parses each line as the JSON format writes it
+func (c *capturedLog) Lines(t *testing.T) []map[string]interface{} {
+	t.Helper()
+	lines := []map[string]interface{}{}
+	for _, line := range strings.Split(strings.TrimSpace(c.buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			t.Fatalf("log line %q: %v", line, err)
+		}
+		lines = append(lines, fields)
+	}
+	return lines
+}
+
+// captureLog points l at a buffer in the JSON format for the rest of the
+// test, its output, format and precision restored once it's done
+func captureLog(t *testing.T, l *Logger) *capturedLog {
+	t.Helper()
+	out, format, precision := l.Writer(), l.Format(), l.Precision()
+	t.Cleanup(func() {
+		l.SetOutput(out)
+		l.SetFormat(format)
+		l.SetPrecision(precision)
+	})
+
+	c := &capturedLog{}
+	l.SetOutput(&c.buf)
+	l.SetFormat(LogFormatJSON)
+	// Under a second, JSON lines get a time field instead of a prefix
+	l.SetPrecision(time.Millisecond)
+	return c
+}
+
+func TestLoggerMiddlewareLogsStatus(t *testing.T) {
+	access := captureLog(t, accessLog)
+
+	h := LoggerMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("short and stout"))
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/teapot", nil))
+
+	lines := access.Lines(t)
+	if len(lines) != 1 {
+		t.Fatalf("got %d access lines, want 1: %s", len(lines), access)
+	}
+	line := lines[0]
+	if line["status"] != float64(http.StatusTeapot) || line["method"] != "GET" || line["path"] != "/teapot" {
+		t.Errorf("got %v, want a GET /teapot 418", line)
+	}
+	if line["response_bytes"] != float64(len("short and stout")) {
+		t.Errorf("got response_bytes %v, want %d", line["response_bytes"], len("short and stout"))
+	}
+}
+
+func TestLoggerLevel(t *testing.T) {
+	access := captureLog(t, accessLog)
+	level := accessLog.Level()
+	t.Cleanup(func() { accessLog.SetLevel(level) })
+	accessLog.SetLevel(LevelWarn)
+
+	h := LoggerMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	for _, path := range []string{"/found", "/missing"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	lines := access.Lines(t)
+	if len(lines) != 1 || lines[0]["path"] != "/missing" {
+		t.Errorf("got %v, want only the 404 at warn level", lines)
+	}
+}
@@ -2,13 +2,18 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
 	"fmt"
 	"html/template"
 	"log"
 	"math"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"runtime/debug"
 	"strings"
 	"time"
 
@@ -22,56 +27,162 @@ type Colors struct {
 	Blue    string
 	Magenta string
 	Cyan    string
+	Dim     string
 	Reset   string
+
+	statusClasses [6]string
+}
+
+// withStatusClasses precomputes the color of each status class, indexed by
+// status/100, with anything outside 1xx-4xx colored as an error.
+func (c *Colors) withStatusClasses() *Colors {
+	c.statusClasses = [6]string{c.Red, c.Cyan, c.Green, c.Yellow, c.Magenta, c.Red}
+	return c
 }
 
-var colors *Colors = &Colors{
+var colors *Colors = (&Colors{
 	Red:     "\033[31m",
 	Green:   "\033[32m",
 	Yellow:  "\033[33m",
 	Blue:    "\033[34m",
 	Magenta: "\033[35m",
 	Cyan:    "\033[36m",
+	Dim:     "\033[2m",
 	Reset:   "\033[0m",
-}
+}).withStatusClasses()
+
+// StatusClientClosedRequest is nginx's status for clients that went away
+// before getting a response
+const StatusClientClosedRequest int = 499
 
 func GetStatusColor(status int) string {
-	switch {
-	case status >= 100 && status < 200:
-		return colors.Cyan
-	case status >= 200 && status < 300:
-		return colors.Green
-	case status >= 300 && status < 400:
-		return colors.Yellow
-	case status >= 400 && status < 500:
-		return colors.Magenta
-	default:
+	if status == StatusClientClosedRequest {
+		return colors.Blue
+	}
+	class := status / 100
+	if class < 0 || class >= len(colors.statusClasses) {
 		return colors.Red
 	}
+	return colors.statusClasses[class]
 }
 
 type ResponseRecorderWriter struct {
 	http.ResponseWriter
-	Status int
+	Status    int
+	FirstByte time.Time
+	Bytes     int64
+	// WriteErr is the last error writing the body, usually the client
+	// having gone away mid response
+	WriteErr error
+
+	headerHooks []func(status int, header http.Header)
+}
+
+func (rr *ResponseRecorderWriter) Written() bool {
+	return !rr.FirstByte.IsZero()
+}
+
+// OnWriteHeader runs hook on the response headers right before they are
+// forwarded, whether explicitly or by the first Write or Flush
+func (rr *ResponseRecorderWriter) OnWriteHeader(hook func(status int, header http.Header)) {
+	rr.headerHooks = append(rr.headerHooks, hook)
 }
 
 func (rr *ResponseRecorderWriter) WriteHeader(status int) {
 	rr.Status = status
+	rr.markFirstByte()
 	rr.ResponseWriter.WriteHeader(status)
 }
 
+func (rr *ResponseRecorderWriter) Write(b []byte) (int, error) {
+	rr.markFirstByte()
+	n, err := rr.ResponseWriter.Write(b)
+	rr.Bytes += int64(n)
+	if err != nil {
+		rr.WriteErr = err
+	}
+	return n, err
+}
+
+func (rr *ResponseRecorderWriter) markFirstByte() {
+	if rr.FirstByte.IsZero() {
+		rr.FirstByte = time.Now()
+		for _, hook := range rr.headerHooks {
+			hook(rr.Status, rr.Header())
+		}
+	}
+}
+
+func (rr *ResponseRecorderWriter) Flush() {
+	rr.markFirstByte()
+	if f, ok := rr.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rr *ResponseRecorderWriter) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
+
 type RequestLogger struct {
+	id     string
 	method string
 	status int
 	since  time.Duration
 	path   string
 	color  string
+
+	servedBy   string
+	tlsVersion string
+	tlsCipher  string
+	serverName string
+	ttfb       time.Duration
+
+	contentType string
+	message     string
+	cancelled   bool
+	writeError  string
+
+	country string
+	region  string
+	route   string
+
+	requestBytes        int64
+	requestBytesCounted bool
+
+	host       string
+	apiVersion int
+
+	panicType  string
+	panicChain []panicCause
+	panicStack string
+
+	start         time.Time
+	clientIP      string
+	user          string
+	requestURI    string
+	proto         string
+	responseBytes int64
+
+	queueTime       time.Duration
+	queueTimeQueued bool
+
+	tlsHandshake      time.Duration
+	tlsHandshakeTimed bool
+
+	baggage Baggage
 }
 
 func NewRequestLoggerBuilder() *RequestLogger {
 	return &RequestLogger{}
 }
 
+func (rl *RequestLogger) SetRequestID(id string) *RequestLogger {
+	rl.id = id
+	return rl
+}
+
 func (rl *RequestLogger) SetMethod(method string) *RequestLogger {
 	rl.method = method
 	return rl
@@ -93,6 +204,167 @@ func (rl *RequestLogger) SetStatus(status int) *RequestLogger {
 	return rl
 }
 
+func (rl *RequestLogger) SetServedBy(servedBy string) *RequestLogger {
+	rl.servedBy = servedBy
+	return rl
+}
+
+// SetTLS records the connection's version, cipher and SNI server name, the
+// latter being "-" over plain HTTP or when the client sent none
+func (rl *RequestLogger) SetTLS(state *tls.ConnectionState) *RequestLogger {
+	rl.serverName = "-"
+	if state != nil {
+		rl.tlsVersion = strings.ReplaceAll(tls.VersionName(state.Version), " ", "v")
+		rl.tlsCipher = tls.CipherSuiteName(state.CipherSuite)
+		if state.ServerName != "" {
+			rl.serverName = state.ServerName
+		}
+	}
+	return rl
+}
+
+func (rl *RequestLogger) SetTTFB(ttfb time.Duration) *RequestLogger {
+	rl.ttfb = ttfb
+	return rl
+}
+
+// SetContentType records the Content-Type the handler set, "-" when it didn't
+func (rl *RequestLogger) SetContentType(contentType string) *RequestLogger {
+	if contentType == "" {
+		contentType = "-"
+	}
+	rl.contentType = contentType
+	return rl
+}
+
+// SetMessage turns the entry into a message line, like a panic or a rejection,
+// instead of a plain access line
+func (rl *RequestLogger) SetMessage(message string) *RequestLogger {
+	rl.message = message
+	return rl
+}
+
+// SetPanic turns the entry into a panic line, keeping the recovered value's
+// type and, for errors, their whole wrapping chain for the JSON format
+func (rl *RequestLogger) SetPanic(v interface{}) *RequestLogger {
+	rl.panicType = panicType(v)
+	rl.panicChain = panicChain(v)
+	return rl.SetMessage(panicMessage(v))
+}
+
+// SetPanicStack records the panicking goroutine's stack, as debug.Stack
+// prints it
+func (rl *RequestLogger) SetPanicStack(stack []byte) *RequestLogger {
+	rl.panicStack = string(stack)
+	return rl
+}
+
+func (rl *RequestLogger) SetBaggage(baggage Baggage) *RequestLogger {
+	rl.baggage = baggage
+	return rl
+}
+
+func (rl *RequestLogger) SetCancelled(cancelled bool) *RequestLogger {
+	rl.cancelled = cancelled
+	return rl
+}
+
+// SetWriteError records why the response was cut short, a nil err meaning
+// it was written whole
+func (rl *RequestLogger) SetWriteError(err error) *RequestLogger {
+	if err != nil {
+		rl.writeError = err.Error()
+	}
+	return rl
+}
+
+// SetRoute records the route template, logged apart from the raw path by
+// the JSON format to keep aggregations low cardinality
+func (rl *RequestLogger) SetRoute(route string) *RequestLogger {
+	rl.route = route
+	return rl
+}
+
+// SetRequestBytes records how much of the request body was read, logged
+// apart from the response size
+func (rl *RequestLogger) SetRequestBytes(n int64, counted bool) *RequestLogger {
+	rl.requestBytes = n
+	rl.requestBytesCounted = counted
+	return rl
+}
+
+// SetQueueTime records how long the request waited for a concurrency slot,
+// logged apart from the duration when it went through the limiter
+func (rl *RequestLogger) SetQueueTime(waited time.Duration, queued bool) *RequestLogger {
+	rl.queueTime = waited
+	rl.queueTimeQueued = queued
+	return rl
+}
+
+// SetTLSHandshake records how long the connection's TLS handshake took,
+// only told with the first request on the connection
+func (rl *RequestLogger) SetTLSHandshake(d time.Duration, timed bool) *RequestLogger {
+	rl.tlsHandshake = d
+	rl.tlsHandshakeTimed = timed
+	return rl
+}
+
+func (rl *RequestLogger) SetHost(host string) *RequestLogger {
+	rl.host = host
+	return rl
+}
+
+func (rl *RequestLogger) SetAPIVersion(version int) *RequestLogger {
+	rl.apiVersion = version
+	return rl
+}
+
+func (rl *RequestLogger) SetStart(start time.Time) *RequestLogger {
+	rl.start = start
+	return rl
+}
+
+func (rl *RequestLogger) SetClientIP(ip net.IP) *RequestLogger {
+	if ip != nil {
+		rl.clientIP = ip.String()
+	}
+	return rl
+}
+
+func (rl *RequestLogger) SetUser(user string) *RequestLogger {
+	rl.user = user
+	return rl
+}
+
+// SetRequestLine records the request URI and protocol, the raw path being
+// what the text formats already show
+func (rl *RequestLogger) SetRequestLine(requestURI, proto string) *RequestLogger {
+	rl.requestURI = requestURI
+	return rl.SetProto(proto)
+}
+
+// SetProto records the protocol, like HTTP/1.1 or HTTP/2.0, for entries
+// without a whole request line
+func (rl *RequestLogger) SetProto(proto string) *RequestLogger {
+	rl.proto = proto
+	return rl
+}
+
+func (rl *RequestLogger) SetResponseBytes(n int64) *RequestLogger {
+	rl.responseBytes = n
+	return rl
+}
+
+func (rl *RequestLogger) SetGeo(geo Geo) *RequestLogger {
+	rl.country = geo.Country
+	rl.region = geo.Region
+	return rl
+}
+
+func (rl RequestLogger) GetRequestID() string {
+	return rl.id
+}
+
 func (rl RequestLogger) GetMethod() string {
 	return rl.method
 }
@@ -109,40 +381,192 @@ func (rl RequestLogger) GetPath() string {
 	return rl.path
 }
 
+func (rl RequestLogger) GetServedBy() string {
+	return rl.servedBy
+}
+
+func (rl RequestLogger) GetTLSVersion() string {
+	return rl.tlsVersion
+}
+
+func (rl RequestLogger) GetTLSCipher() string {
+	return rl.tlsCipher
+}
+
+func (rl RequestLogger) GetServerName() string {
+	return rl.serverName
+}
+
+func (rl RequestLogger) GetTTFB() time.Duration {
+	return rl.ttfb
+}
+
+func (rl RequestLogger) GetContentType() string {
+	return rl.contentType
+}
+
+func (rl RequestLogger) GetMessage() string {
+	return rl.message
+}
+
+func (rl RequestLogger) GetCancelled() bool {
+	return rl.cancelled
+}
+
+func (rl RequestLogger) GetTLSHandshake() (time.Duration, bool) {
+	return rl.tlsHandshake, rl.tlsHandshakeTimed
+}
+
+func (rl RequestLogger) GetWriteError() string {
+	return rl.writeError
+}
+
+func (rl RequestLogger) GetCountry() string {
+	return rl.country
+}
+
+func (rl RequestLogger) GetRegion() string {
+	return rl.region
+}
+
+func (rl RequestLogger) GetRoute() string {
+	return rl.route
+}
+
+func (rl RequestLogger) GetRequestBytes() (int64, bool) {
+	return rl.requestBytes, rl.requestBytesCounted
+}
+
+func (rl RequestLogger) GetQueueTime() (time.Duration, bool) {
+	return rl.queueTime, rl.queueTimeQueued
+}
+
+func (rl RequestLogger) GetHost() string {
+	return rl.host
+}
+
+func (rl RequestLogger) GetPanicType() string {
+	return rl.panicType
+}
+
+func (rl RequestLogger) GetPanicChain() []panicCause {
+	return rl.panicChain
+}
+
+func (rl RequestLogger) GetPanicStack() string {
+	return rl.panicStack
+}
+
+func (rl RequestLogger) GetBaggage() Baggage {
+	return rl.baggage
+}
+
+func (rl RequestLogger) GetAPIVersion() int {
+	return rl.apiVersion
+}
+
+func (rl RequestLogger) GetStart() time.Time {
+	return rl.start
+}
+
+func (rl RequestLogger) GetClientIP() string {
+	return rl.clientIP
+}
+
+func (rl RequestLogger) GetUser() string {
+	return rl.user
+}
+
+func (rl RequestLogger) GetRequestURI() string {
+	return rl.requestURI
+}
+
+func (rl RequestLogger) GetProto() string {
+	return rl.proto
+}
+
+func (rl RequestLogger) GetResponseBytes() int64 {
+	return rl.responseBytes
+}
+
 func (rl RequestLogger) String() string {
-	return fmt.Sprintf(
-		"| %s | %s | %s | %s",
+	return rl.joinColumns(
 		rl.padAndColor(7, rl.GetMethod()),
 		rl.padAndColor(0, rl.GetStatus()),
 		rl.pad(12, rl.GetSince()),
-		rl.GetPath(),
+		sanitize(rl.GetPath()),
 	)
 }
 
-func (rl RequestLogger) PanicString(err interface{}) string {
+// panicIndent lines up a panic's details below its access line columns
+const panicIndent string = "    "
 
-	stringer := func(e string) string {
-		coloredError := rl.color + e + colors.Reset
-		const tmpl string = "| %s | %s |             | %s %s"
-		return fmt.Sprintf(
-			tmpl,
-			rl.padAndColor(7, rl.GetMethod()),
-			rl.padAndColor(0, rl.GetStatus()),
-			rl.GetPath(),
-			coloredError,
-		)
+// PanicString lays a panic out as an access line, then the panic and every
+// error it wraps indented below it, and the stack when one was recorded.
+// Only the labels are colored, each detail is its own line either way.
+func (rl RequestLogger) PanicString() string {
+	var b strings.Builder
+	b.WriteString(rl.String())
+
+	fmt.Fprintf(
+		&b, "\n%s%spanic%s %s: %s",
+		panicIndent, rl.color, colors.Reset, sanitize(rl.GetPanicType()), sanitize(rl.GetMessage()),
+	)
+	// The first of the chain is the panic itself
+	if chain := rl.GetPanicChain(); len(chain) > 1 {
+		for _, cause := range chain[1:] {
+			fmt.Fprintf(
+				&b, "\n%s%scaused by%s %s: %s",
+				panicIndent, colors.Yellow, colors.Reset, sanitize(cause.Type), sanitize(cause.Message),
+			)
+		}
+	}
+	if stack := rl.GetPanicStack(); stack != "" {
+		fmt.Fprintf(&b, "\n%s%sstack%s", panicIndent, colors.Dim, colors.Reset)
+		for _, line := range strings.Split(strings.TrimRight(stack, "\n"), "\n") {
+			line = strings.Replace(line, "\t", panicIndent, 1)
+			b.WriteString("\n" + panicIndent + panicIndent + colors.Dim + sanitize(line) + colors.Reset)
+		}
 	}
+	return b.String()
+}
 
-	e, ok := err.(error)
-	if !ok {
-		return stringer("Unknown")
+func panicMessage(err interface{}) string {
+	if e, ok := err.(error); ok {
+		return e.Error()
 	}
-	return stringer(e.Error())
+	return fmt.Sprint(err)
+}
+
+func (rl RequestLogger) MessageString(message string) string {
+	var (
+		coloredMessage string      = rl.color + sanitize(message) + colors.Reset
+		since          interface{} = ""
+	)
+	if rl.GetSince() > 0 {
+		since = rl.GetSince()
+	}
+	return rl.joinColumns(
+		rl.padAndColor(7, rl.GetMethod()),
+		rl.padAndColor(0, rl.GetStatus()),
+		rl.pad(12, since),
+		sanitize(rl.GetPath())+" "+coloredMessage,
+	)
+}
+
+// joinColumns lays out the columns between separators, led by the request ID
+// when there is one
+func (rl RequestLogger) joinColumns(columns ...string) string {
+	if rl.GetRequestID() != "" {
+		columns = append([]string{rl.GetRequestID()}, columns...)
+	}
+	sep := logTheme.Sep()
+	return sep + " " + strings.Join(columns, " "+sep+" ")
 }
 
 func (rl RequestLogger) pad(padding int, value interface{}) string {
 	var (
-		v string = fmt.Sprint(value)
+		v string = sanitize(fmt.Sprint(value))
 		r int    = int(math.Max(float64(padding-len(v)), 0))
 	)
 	return v + strings.Repeat(" ", r)
@@ -155,56 +579,180 @@ func (rl RequestLogger) padAndColor(padding int, value interface{}) string {
 	return rl.color + fmt.Sprint(value) + colors.Reset
 }
 
+// RecoveryMiddleware turns a handler panic into a logged 500. When logging
+// it or writing the 500 panics in turn, both values are logged. The 500 is
+// only written when the handler hadn't answered yet, and never twice.
 func RecoveryMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pw := &panicWriter{ResponseWriter: w}
+
 		defer func() {
-			if err := recover(); err != nil {
+			err := recover()
+			if err == nil {
+				return
+			}
+			err, stack := unwrapPanic(err)
+			if !panicStacks {
+				stack = nil
+			} else if stack == nil {
+				stack = debug.Stack()
+			}
+
+			func() {
+				defer func() {
+					if again := recover(); again != nil {
+						log.Printf(
+							"| %s | panic %q while recovering from panic %q",
+							RequestIDFromContext(r), panicMessage(again), panicMessage(err),
+						)
+					}
+				}()
+
 				rl :=
 					NewRequestLoggerBuilder().
+						SetRequestID(RequestIDFromContext(r)).
 						SetMethod(r.Method).
 						SetStatus(http.StatusInternalServerError).
-						SetPath(r.URL.Path)
+						SetPath(r.URL.Path).
+						SetSince(time.Since(RequestStart(r))).
+						SetProto(r.Proto).
+						SetPanic(err).
+						SetPanicStack(stack)
 
-				log.Println(rl.PanicString(err))
-				w.WriteHeader(rl.GetStatus())
+				panicsTotal.Inc(RouteName(r))
+				errorLog.Print(LevelError, rl)
+			}()
+
+			if pw.wroteHeader {
+				return
+			}
+			defer func() {
+				if again := recover(); again != nil {
+					log.Printf(
+						"| %s | panic %q while answering the 500 of panic %q",
+						RequestIDFromContext(r), panicMessage(again), panicMessage(err),
+					)
+				}
+			}()
+			if AcceptsProblem(r) {
+				WriteProblem(pw, r, Problem{Status: http.StatusInternalServerError})
+			} else {
+				pw.WriteHeader(http.StatusInternalServerError)
 			}
 		}()
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(pw, r)
 	})
 }
 
+// LoggerMiddleware writes the access line of a request once its handler
+// returns. A panicking one gets a 500 access line as the panic unwinds
+// through it, RecoveryMiddleware outside it then logging the panic itself
+// in the error log and answering that 500.
 func LoggerMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
+		// An outer LoggerMiddleware already records and logs this request
+		if _, ok := w.(*ResponseRecorderWriter); ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := RequestStart(r)
 
 		writer := &ResponseRecorderWriter{
 			ResponseWriter: w,
 			Status:         http.StatusOK,
 		}
 
-		next.ServeHTTP(writer, r)
+		// Without recover, so the panic goes on to RecoveryMiddleware
+		panicking := true
+		defer func() {
+			if !panicking {
+				return
+			}
+			// RecoveryMiddleware only answers when the handler hadn't
+			if !writer.Written() {
+				writer.Status = http.StatusInternalServerError
+			}
+			logAccess(writer, r, start)
+		}()
 
-		log.Println(
-			NewRequestLoggerBuilder().
-				SetMethod(r.Method).
-				SetStatus(writer.Status).
-				SetPath(r.URL.Path).
-				SetSince(time.Since(start)),
-		)
+		next.ServeHTTP(writer, r)
+		panicking = false
+		logAccess(writer, r, start)
 	})
 }
 
+// logAccess counts and logs the response writer recorded for r
+func logAccess(writer *ResponseRecorderWriter, r *http.Request, start time.Time) {
+	// The client went away before the handler answered anything
+	cancelled := errors.Is(r.Context().Err(), context.Canceled)
+	if cancelled && !writer.Written() {
+		writer.Status = StatusClientClosedRequest
+	}
+
+	statusCounters.Observe(writer.Status)
+	errorRates.Observe(RouteName(r), writer.Status)
+	errorPenalties.Observe(RateLimitByIP(r), writer.Status)
+	if n, counted := RequestBytesRead(r); counted {
+		requestSize.Observe(float64(n), RouteName(r))
+	}
+	responseSize.Observe(float64(writer.Bytes), RouteName(r))
+
+	if logExclusions.Skip(r.URL.Path, writer.Status) {
+		return
+	}
+
+	rl :=
+		NewRequestLoggerBuilder().
+			SetRequestID(RequestIDFromContext(r)).
+			SetMethod(r.Method).
+			SetStatus(writer.Status).
+			SetPath(r.URL.Path).
+			SetRoute(RouteTemplate(r)).
+			SetSince(time.Since(start)).
+			SetServedBy(writer.Header().Get(ServedByHeader)).
+			SetTLS(r.TLS).
+			SetContentType(writer.Header().Get("Content-Type")).
+			SetCancelled(cancelled).
+			SetGeo(GeoFromContext(r)).
+			SetBaggage(requestBaggage(r)).
+			SetRequestBytes(RequestBytesRead(r)).
+			SetQueueTime(QueueTimeFromContext(r)).
+			SetTLSHandshake(TLSHandshakeFromContext(r)).
+			SetHost(r.Host).
+			SetAPIVersion(VersionFromContext(r)).
+			SetStart(start).
+			SetClientIP(ClientIP(r)).
+			SetUser(basicAuthUser(r)).
+			SetRequestLine(r.RequestURI, r.Proto).
+			SetResponseBytes(writer.Bytes).
+			SetWriteError(writer.WriteErr)
+
+	// The status alone would pass a partial response for a clean one
+	if writer.WriteErr != nil {
+		LoggerFromContext(r).Printf("response cut short after %d bytes: %v", writer.Bytes, writer.WriteErr)
+	}
+	if writer.Written() {
+		rl.SetTTFB(writer.FirstByte.Sub(start))
+	}
+
+	recentRequests.Add(rl)
+	accessLog.Print(StatusLevel(rl.GetStatus()), rl)
+}
+
 func NotFoundHandler(r *mux.Router) http.Handler {
 	return r.
 		NewRoute().
 		BuildOnly().
-		Handler(LoggerMiddleware(http.HandlerFunc(http.NotFound))).
+		Handler(LoggerMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			writeError(w, r, http.StatusNotFound, "404 page not found")
+		}))).
 		GetHandler()
 }
 
 func MethodNotAllowedHandler(r *mux.Router) http.Handler {
 	e := func(w http.ResponseWriter, r *http.Request) {
-		http.Error(w, "", http.StatusMethodNotAllowed)
+		writeError(w, r, http.StatusMethodNotAllowed, "")
 	}
 
 	return r.
@@ -216,60 +764,396 @@ func MethodNotAllowedHandler(r *mux.Router) http.Handler {
 
 func main() {
 
-	indexView, err := template.ParseFiles("index.html")
+	cfg, err := LoadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
 	if err != nil {
 		log.Fatalln(err)
 	}
+	currentConfig.Store(cfg)
+
+	pathRules, err := NewPathRules(cfg.PathRules, cfg.MaxPathLength)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	minTLSVersion, err := ParseTLSVersion(cfg.MinTLSVersion)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	indexView, err := template.New("index.html").Funcs(TemplateFuncs(nil)).ParseFiles("index.html")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if err := SetupLoggers(cfg); err != nil {
+		log.Fatalln(err)
+	}
+
+	favicon, err := LoadFavicon(cfg.Favicon)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	geoIP, err := OpenGeoIP(cfg.GeoIPDatabase)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	OnShutdown("geoip", func(context.Context) error { return geoIP.Close() })
+	SetupMetrics(cfg)
+	SetSlowRenderThreshold(time.Duration(cfg.SlowRenderThreshold))
+	SetRenderBufferSize(cfg.RenderBufferSize)
+	logExclusions = cfg.LogExclusions
+	// Browsers ask for it on every page, it's nothing worth logging
+	logExclusions.Prefixes = append([]string{cfg.FaviconPath}, cfg.LogExclusions.Prefixes...)
+	retryAfterJitter = time.Duration(cfg.RetryAfterJitter)
 
 	router := mux.NewRouter()
 
 	router.NotFoundHandler = NotFoundHandler(router)
 	router.MethodNotAllowedHandler = MethodNotAllowedHandler(router)
 
-	router.Use(RecoveryMiddleware, LoggerMiddleware)
+	var apiVersions *APIVersions
+	if len(cfg.APIVersions) > 0 {
+		apiVersions, err = NewAPIVersions(cfg.APIVendor, cfg.APIVersions, cfg.APIDefaultVersion)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	var csrf *CSRF
+	if cfg.CSRF {
+		if csrf, err = NewCSRF(cfg.CSRFSecret, cfg.CSRFCookie); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	statusRewrites, err := cfg.StatusRewrites.Compile()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	// Validated along with the rest of the config
+	rateLimitKey, _ := ParseRateLimitKey(cfg.RateLimitBy)
+
+	busyPage, err := LoadBusyPage(cfg.BusyPage)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	chaos := NewChaos(
+		cfg.Debug,
+		cfg.ChaosRoutes,
+		time.Duration(cfg.ChaosMinLatency),
+		time.Duration(cfg.ChaosMaxLatency),
+		cfg.ChaosErrorPercent,
+	)
+	if chaos != nil {
+		log.Println("| Chaos on routes: " + strings.Join(cfg.ChaosRoutes, ", "))
+	} else if len(cfg.ChaosRoutes) > 0 {
+		log.Println("| Chaos routes ignored without DEBUG")
+	}
 
-	router.
-		Name("get_not_allowed").
-		Path("/get_not_allowed").
-		HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var middlewares Middlewares
+	middlewares.TimeSlowRequests(time.Duration(cfg.MiddlewareTiming))
+	if cfg.Recover {
+		middlewares.Add(PriorityRecovery, "recovery", RecoveryMiddleware)
+	} else {
+		middlewares.Add(PriorityRecovery, "crash", CrashMiddleware)
+	}
+	middlewares.Add(PriorityLogger, "logger", LoggerMiddleware)
+	middlewares.Add(PriorityDryRun, "dry_run", DryRunMiddleware(cfg.DryRun))
+	middlewares.Add(PriorityChaos, "chaos", ChaosMiddleware(chaos))
+	middlewares.Add(PriorityRouteSwitch, "route_switch", RouteSwitchMiddleware(disabledRoutes))
+	middlewares.Add(
+		PriorityHMAC,
+		"hmac",
+		SignedRoutesMiddleware(
+			cfg.HMACRoutes,
+			HMACVerifyMiddleware([]byte(cfg.HMACSecret), cfg.HMACHeader, cfg.HMACEncoding),
+		),
+	)
+	middlewares.Add(PriorityCSRF, "csrf", CSRFMiddleware(csrf))
+	middlewares.Add(PriorityHopByHop, "hop_by_hop", HopByHopMiddleware)
+	middlewares.Add(PriorityGzip, "gzip", GzipMiddleware(cfg.GzipLevel))
+	middlewares.Add(
+		PriorityStatusRewrite,
+		"status_rewrite",
+		StatusRewriteMiddleware(statusRewrites, cfg.StatusRewriteMaxBody),
+	)
+	middlewares.Add(
+		PrioritySingleFlight,
+		"single_flight",
+		SingleFlightMiddleware(cfg.SingleFlightRoutes, cfg.SingleFlightMaxBody),
+	)
+	middlewares.Add(PriorityRouteHeaders, "route_headers", RouteHeadersMiddleware(cfg.RouteHeaders))
+	middlewares.Add(
+		PriorityContentType,
+		"content_type",
+		ContentTypeCheckMiddleware(cfg.ContentTypeCheck, cfg.DefaultContentType),
+	)
+	if cfg.Debug && cfg.SafeMethodCheck {
+		middlewares.Add(
+			PrioritySafeMethod,
+			"safe_method",
+			SafeMethodCheckMiddleware(cfg.SafeMethodHeaders, []string{cfg.CSRFCookie}),
+		)
+	} else if cfg.SafeMethodCheck {
+		log.Println("| Safe method check ignored without DEBUG")
+	}
+	if cfg.Debug {
+		middlewares.Add(
+			PriorityCancelProbe,
+			"cancel_probe",
+			CancelProbeMiddleware(time.Duration(cfg.CancelProbe), time.Duration(cfg.CancelProbeGrace)),
+		)
+	}
+	middlewares.Add(
+		PriorityWriteDeadline,
+		"write_deadline",
+		WriteDeadlineMiddleware(
+			time.Duration(cfg.WriteTimeout),
+			time.Duration(cfg.WriteDeadlineMargin),
+			cfg.RouteTimeouts,
+		),
+	)
+	middlewares.Add(
+		PriorityWatchdog,
+		"watchdog",
+		WatchdogMiddleware(time.Duration(cfg.HandlerTimeout), cfg.RouteTimeouts),
+	)
+	router.Use(middlewares.Ordered()...)
+
+	HandleWithOptions(
+		router,
+		"get_not_allowed",
+		"/get_not_allowed",
+		[]string{"POST", "PUT"},
+		func(w http.ResponseWriter, r *http.Request) {
 			w.Write([]byte("Ok: " + r.Method))
-		}).
-		Methods("POST", "PUT")
+		},
+	)
 
 	// Proposital nil pointer panic
-	router.
-		Name("nil_pointer").
-		Path("/nil_pointer").
-		HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	Handle(
+		router,
+		"nil_pointer",
+		"/nil_pointer",
+		[]string{"GET"},
+		func(w http.ResponseWriter, r *http.Request) {
 			var s struct{ n *struct{ n int } }
 			w.Write([]byte(fmt.Sprint(s.n.n)))
-		}).
-		Methods("GET")
+		},
+	)
+
+	// Proposital slow handler, overruns a watchdog deadline under 3s
+	Handle(
+		router,
+		"slow",
+		"/slow",
+		[]string{"GET"},
+		func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-time.After(3 * time.Second):
+				w.Write([]byte("Ok"))
+			case <-r.Context().Done():
+			}
+		},
+	)
+
+	Handle(router, "events", "/events", []string{"GET"}, EventsHandler)
+
+	// Path variables are validated before the handler, which can trust them
+	Handle(
+		router,
+		"item",
+		"/items/{id}",
+		[]string{"GET"},
+		ValidateVarsMiddleware(map[string]VarValidator{"id": Numeric})(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("Item: " + mux.Vars(r)["id"]))
+			}),
+		).ServeHTTP,
+	)
 
-	router.
-		Name("index").
-		Path("/").
-		HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			indexView.Execute(w, nil)
-		}).
-		Methods("GET")
+	Handle(router, "healthz", HealthPath, []string{"GET", "HEAD"}, HealthHandler)
+	Handle(router, "readyz", ReadyPath, []string{"GET", "HEAD"}, ReadyHandler)
+	Handle(router, "favicon", cfg.FaviconPath, []string{"GET", "HEAD"}, FaviconHandler(favicon))
 
-	port := ":8000"
+	AdminRoutes(router, cfg)
+
+	if metrics != nil {
+		Handle(router, "metrics", "/metrics", []string{"GET"}, metrics.Handler())
+	}
+
+	if cfg.Debug {
+		recentRequests = NewRecentRequests(cfg.RecentRequests)
+		Handle(
+			router,
+			"debug_requests",
+			"/debug/requests",
+			[]string{"GET"},
+			RecentRequestsHandler(recentRequests),
+		)
+		Handle(router, "debug_replay", ReplayPath, []string{"POST"}, ReplayHandler(router))
+	}
+
+	if cfg.RuntimeVars {
+		Handle(router, "debug_vars", "/debug/vars", []string{"GET"}, RuntimeVarsHandler)
+	}
+
+	errorRates = NewErrorRates(
+		cfg.ErrorRatePercent,
+		time.Duration(cfg.ErrorRateWindow),
+		cfg.ErrorRateMinRequests,
+	)
+	if errorRates != nil {
+		Handle(router, "debug_error_rates", "/debug/error_rates", []string{"GET"}, ErrorRatesHandler(errorRates))
+	}
+
+	if cfg.DebugConfig {
+		Handle(router, "debug_config", "/debug/config", []string{"GET"}, ConfigHandler)
+	}
+
+	Handle(
+		router,
+		"index",
+		"/",
+		[]string{"GET"},
+		NegotiateMiddleware("text/html")(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				Render(w, r, indexView, nil)
+			}),
+		).ServeHTTP,
+	)
+
+	if err := CheckRoutes(router, cfg.MinRoutes); err != nil {
+		if cfg.StrictRoutes {
+			log.Fatalln(err)
+		}
+		log.Println("| Warning: " + err.Error())
+	}
+
+	// Wrapped inside out, so the last one runs first
+	var handler http.Handler = router
+	handler = ConcurrencyLimitMiddleware(
+		cfg.MaxConcurrentRequests,
+		time.Duration(cfg.QueueTimeout),
+	)(handler)
+	handler = LoadShedMiddleware(cfg.RequestBudget, busyPage, time.Duration(cfg.QueueTimeout))(handler)
+	handler = ReadOnlyMiddleware(
+		readOnly,
+		time.Duration(cfg.ReadOnlyRetryAfter),
+		cfg.ReadOnlyMessage,
+	)(handler)
+	handler = MaintenanceMiddleware(
+		maintenance,
+		time.Duration(cfg.MaintenanceRetryAfter),
+		cfg.MaintenanceMessage,
+	)(handler)
+	handler = MemoryGuardMiddleware(uint64(cfg.MaxHeapInuse))(handler)
+	handler = RequestBodySizeMiddleware(handler)
+	handler = SlowBodyMiddleware(time.Duration(cfg.SlowBodyThreshold))(handler)
+	handler = TLSMiddleware(minTLSVersion)(handler)
+	if cfg.Debug && len(cfg.CurlLogPrefixes) > 0 {
+		handler = CurlLogMiddleware(cfg.CurlLogPrefixes...)(handler)
+	}
+	if cfg.Debug && len(cfg.BodyLogPrefixes) > 0 {
+		handler = BodyLogMiddleware(cfg.BodyLogLimit, cfg.BodyLogPrefixes...)(handler)
+	}
+	handler = CanonicalPathMiddleware(cfg.CanonicalPaths, cfg.CanonicalPrefixes...)(handler)
+	handler = PathGuardMiddleware(pathRules...)(handler)
+	handler = AsteriskOptionsMiddleware(RouterMethods(router)...)(handler)
+	handler = HostAllowlistMiddleware(cfg.AllowedHosts...)(handler)
+	handler = ContentLengthMiddleware(int64(cfg.MaxBodySize))(handler)
+	handler = RequestLimitsMiddleware(cfg.MaxHeaderCount, cfg.MaxURLLength)(handler)
+	handler = RateLimitMiddleware(
+		NewRateLimiter(cfg.RateLimit, cfg.RouteRateLimits),
+		rateLimitKey,
+		router,
+	)(handler)
+	errorPenalties = NewErrorPenalties(
+		cfg.ErrorPenaltyThreshold,
+		time.Duration(cfg.ErrorPenaltyDecay),
+		cfg.ErrorPenaltyRateLimit,
+	)
+	handler = ErrorPenaltyMiddleware(errorPenalties)(handler)
+	// Inside the override, so an overridden method is blocked like a sent one
+	handler = MethodBlocklistMiddleware(cfg.BlockedMethods...)(handler)
+	handler = MethodOverrideMiddleware(cfg.MethodOverride...)(handler)
+	handler = APIVersionMiddleware(apiVersions)(handler)
+	handler = GeoIPMiddleware(geoIP)(handler)
+	handler = BaggageMiddleware(cfg.BaggageHeaders, cfg.BaggageRedact)(handler)
+	if cfg.SequenceTTL > 0 {
+		handler = SequenceMiddleware(
+			NewSequenceTracker(time.Duration(cfg.SequenceTTL), cfg.SequenceSessions, cfg.SequenceTolerance),
+			cfg.SequenceSessionHeader,
+		)(handler)
+	}
+	if cfg.RequestIDTTL > 0 {
+		handler = UniqueRequestIDMiddleware(
+			NewRequestIDCache(time.Duration(cfg.RequestIDTTL), cfg.RequestIDCacheSize),
+		)(handler)
+	}
+	handler = RequestIDMiddleware(handler)
+	handler = ServedByMiddleware(cfg.ServedBy)(handler)
 
 	srv := &http.Server{
-		Handler:      router,
-		Addr:         "127.0.0.1" + port,
-		WriteTimeout: 15 * time.Second,
+		Handler:      handler,
+		Addr:         cfg.Addr,
+		WriteTimeout: time.Duration(cfg.WriteTimeout),
 		ReadTimeout:  15 * time.Second,
+		TLSConfig:    &tls.Config{MinVersion: minTLSVersion},
+		// OPTIONS * is answered by AsteriskOptionsMiddleware
+		DisableGeneralOptionsHandler: true,
 	}
+	srv.RegisterOnShutdown(cancelShutdown)
 
-	log.Println("| Listening at port " + port)
-	// Run our server in a goroutine so that it doesn't block.
-	go func() {
-		if err := srv.ListenAndServe(); err != nil {
-			log.Println(err)
+	if certFile, keyFile := cfg.TLSFiles(); certFile != "" {
+		certs, err := LoadCertReloader(certFile, keyFile)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		srv.TLSConfig.GetCertificate = certs.GetCertificate
+		TimeHandshakes(srv)
+		if cfg.TLSReloadInterval > 0 {
+			go certs.Watch(ShutdownContext(), time.Duration(cfg.TLSReloadInterval))
 		}
-	}()
+	}
+
+	if cfg.StatusSummaryInterval > 0 {
+		go ReportStatusSummary(
+			ShutdownContext(),
+			statusCounters,
+			time.Duration(cfg.StatusSummaryInterval),
+		)
+	}
+
+	if cfg.RuntimeStatsInterval > 0 {
+		go ReportRuntimeStats(ShutdownContext(), time.Duration(cfg.RuntimeStatsInterval))
+	}
+
+	maintenance.Set(cfg.Maintenance, "config")
+	WatchMaintenanceSignal(maintenance)
+	readOnly.Set(cfg.ReadOnly, "config")
+	WatchReadOnlySignal(readOnly)
+	WatchReloadSignal(cfg, os.Args[1:])
+
+	OnShutdown("socket", func(context.Context) error { return RemoveSocket(cfg) })
+
+	// Bound before serving in the background, a taken port is fatal here
+	// instead of logged after claiming to listen
+	addr, err := Start(context.Background(), srv, cfg)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	// Logged as bound, scripts listening on port 0 read the port picked
+	// from this line
+	log.Println("| Listening at " + addr)
+	ready.Store(true)
 
 	c := make(chan os.Signal, 1)
 	// We'll accept graceful shutdowns when quit via SIGINT (Ctrl+C)
@@ -279,15 +1163,11 @@ func main() {
 	// Block until we receive our signal.
 	<-c
 
-	// Create a deadline to wait for.
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
-	defer cancel()
-	// Doesn't block if no connections, but will otherwise wait
-	// until the timeout deadline.
-	srv.Shutdown(ctx)
-	// Optionally, you could run srv.Shutdown in a goroutine and block on
-	// <-ctx.Done() if your application should wait for other services
-	// to finalize based on context cancellation.
+	Shutdown(srv, ShutdownTimeouts{
+		ReadinessDelay: time.Duration(cfg.ShutdownReadinessDelay),
+		Drain:          time.Duration(cfg.ShutdownDrainTimeout),
+		Hook:           time.Duration(cfg.ShutdownHookTimeout),
+	})
 	log.Println("| Shutting down")
 	os.Exit(0)
 }
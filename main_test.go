@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// chainRouter serves the routes behind recovery and logging, as main does
+func chainRouter() *mux.Router {
+	router := mux.NewRouter()
+	router.Use(RecoveryMiddleware, LoggerMiddleware)
+	Handle(router, "ok", "/ok", []string{"GET"}, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+	Handle(router, "nil_pointer", "/nil_pointer", []string{"GET"}, func(w http.ResponseWriter, r *http.Request) {
+		var s struct{ n *struct{ n int } }
+		w.Write([]byte{byte(s.n.n)})
+	})
+	return router
+}
+
+func TestRecoveryLoggerChainPanic(t *testing.T) {
+	access, errs := captureLog(t, accessLog), captureLog(t, errorLog)
+
+	w := httptest.NewRecorder()
+	chainRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/nil_pointer", nil))
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want 500", w.Code)
+	}
+	lines := access.Lines(t)
+	if len(lines) != 1 || lines[0]["status"] != float64(http.StatusInternalServerError) {
+		t.Errorf("got access lines %v, want one 500", lines)
+	}
+	panics := errs.Lines(t)
+	if len(panics) != 1 || panics[0]["panic_type"] != "runtime.errorString" || panics[0]["path"] != "/nil_pointer" {
+		t.Errorf("got error lines %v, want the nil pointer panic", panics)
+	}
+}
+
+func TestRecoveryLoggerChainOK(t *testing.T) {
+	access, errs := captureLog(t, accessLog), captureLog(t, errorLog)
+
+	w := httptest.NewRecorder()
+	chainRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ok", nil))
+
+	if w.Code != http.StatusAccepted {
+		t.Errorf("got status %d, want 202", w.Code)
+	}
+	lines := access.Lines(t)
+	if len(lines) != 1 || lines[0]["status"] != float64(http.StatusAccepted) {
+		t.Errorf("got access lines %v, want one 202", lines)
+	}
+	if errs.String() != "" {
+		t.Errorf("got error lines %q, want none", errs)
+	}
+}
+
+func TestLoggerMiddlewareTwice(t *testing.T) {
+	access := captureLog(t, accessLog)
+
+	h := LoggerMiddleware(LoggerMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+	})))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPut, "/twice", nil))
+
+	lines := access.Lines(t)
+	if len(lines) != 1 || lines[0]["status"] != float64(http.StatusConflict) {
+		t.Errorf("got access lines %v, want a single 409", lines)
+	}
+}
+
+// failingWriter takes the first limit bytes of the body, then fails as a
+// client gone away mid response would
+type failingWriter struct {
+	*httptest.ResponseRecorder
+	limit int
+}
+
+func (fw *failingWriter) Write(b []byte) (int, error) {
+	if fw.Body.Len()+len(b) <= fw.limit {
+		return fw.ResponseRecorder.Write(b)
+	}
+	n, _ := fw.ResponseRecorder.Write(b[:fw.limit-fw.Body.Len()])
+	return n, syscall.EPIPE
+}
+
+func TestLoggerMiddlewareWriteError(t *testing.T) {
+	access, std := captureLog(t, accessLog), captureStdLog(t)
+
+	var errs []error
+	handler := LoggerMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 3; i++ {
+			_, err := w.Write([]byte("0123456789"))
+			errs = append(errs, err)
+		}
+	}))
+	w := &failingWriter{ResponseRecorder: httptest.NewRecorder(), limit: 15}
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stream", nil))
+
+	if errs[0] != nil || !errors.Is(errs[1], syscall.EPIPE) {
+		t.Fatalf("handler got write errors %v, want the second one failing", errs)
+	}
+	lines := access.Lines(t)
+	if len(lines) != 1 {
+		t.Fatalf("got access lines %v, want one", lines)
+	}
+	if lines[0]["status"] != float64(http.StatusOK) || lines[0]["response_bytes"] != float64(15) {
+		t.Errorf("got status %v and %v bytes, want 200 and 15", lines[0]["status"], lines[0]["response_bytes"])
+	}
+	if lines[0]["write_error"] != syscall.EPIPE.Error() {
+		t.Errorf("got write_error %v, want %q", lines[0]["write_error"], syscall.EPIPE.Error())
+	}
+	if !strings.Contains(std.String(), "response cut short after 15 bytes: "+syscall.EPIPE.Error()) {
+		t.Errorf("got log %q, want the cut short response", std.String())
+	}
+}
+
+func TestLoggerMiddlewareCleanWrite(t *testing.T) {
+	access := captureLog(t, accessLog)
+
+	handler := LoggerMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("0123456789"))
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/stream", nil))
+
+	lines := access.Lines(t)
+	if len(lines) != 1 || lines[0]["response_bytes"] != float64(10) {
+		t.Fatalf("got access lines %v, want one of 10 bytes", lines)
+	}
+	if _, ok := lines[0]["write_error"]; ok {
+		t.Errorf("got write_error %v on a clean write", lines[0]["write_error"])
+	}
+}
+
+// statusColorSwitch is how GetStatusColor was written before the table,
+// kept to compare against
+func statusColorSwitch(status int) string {
+	switch {
+	case status == StatusClientClosedRequest:
+		return colors.Blue
+	case status >= 100 && status < 200:
+		return colors.Cyan
+	case status >= 200 && status < 300:
+		return colors.Green
+	case status >= 300 && status < 400:
+		return colors.Yellow
+	case status >= 400 && status < 500:
+		return colors.Magenta
+	default:
+		return colors.Red
+	}
+}
+
+var benchStatuses = []int{200, 201, 204, 301, 304, 400, 404, 499, 500, 503, 0, 99, 600, -1}
+
+func TestGetStatusColor(t *testing.T) {
+	for status := -1000; status < 1000; status++ {
+		if got, want := GetStatusColor(status), statusColorSwitch(status); got != want {
+			t.Fatalf("status %d: got %q, want %q", status, got, want)
+		}
+	}
+}
+
+func BenchmarkGetStatusColor(b *testing.B) {
+	b.Run("table", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			GetStatusColor(benchStatuses[i%len(benchStatuses)])
+		}
+	})
+	b.Run("switch", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			statusColorSwitch(benchStatuses[i%len(benchStatuses)])
+		}
+	})
+}
+
+func TestStartOnPortZero(t *testing.T) {
+	captureStdLog(t)
+	captureLog(t, accessLog)
+
+	cfg := DefaultConfig()
+	cfg.Addr = "127.0.0.1:0"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	addr, err := Start(ctx, &http.Server{Handler: chainRouter()}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.HasSuffix(addr, ":0") || !strings.HasPrefix(addr, "127.0.0.1:") {
+		t.Fatalf("got address %q, want the picked port", addr)
+	}
+
+	res, err := http.Get("http://" + addr + "/ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusAccepted {
+		t.Errorf("got status %d, want 202", res.StatusCode)
+	}
+
+	cancel()
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	for i := 0; i < 50; i++ {
+		if _, err = client.Get("http://" + addr + "/ok"); err != nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("still serving once the context was done")
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type Maintenance struct {
+	on atomic.Bool
+}
+
+var maintenance *Maintenance = &Maintenance{}
+
+func (m *Maintenance) Enabled() bool {
+	return m.on.Load()
+}
+
+// Set switches maintenance mode, logging only actual changes along with what
+// asked for them
+func (m *Maintenance) Set(on bool, by string) {
+	if m.on.Swap(on) == on {
+		return
+	}
+	if on {
+		log.Println("| Entering maintenance mode, by " + by)
+	} else {
+		log.Println("| Leaving maintenance mode, by " + by)
+	}
+}
+
+func (m *Maintenance) Toggle(by string) {
+	m.Set(!m.Enabled(), by)
+}
+
+// MaintenanceMiddleware answers everything but health checks and the admin
+// endpoints with a 503 while maintenance mode is on.
+func MaintenanceMiddleware(m *Maintenance, retryAfter time.Duration, message string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !m.Enabled() || IsHealthCheck(r) || IsAdminPath(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			LogRejection(r, r.URL.Path, http.StatusServiceUnavailable, "maintenance mode")
+			SetRetryAfter(w.Header(), retryAfter)
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(message + "\n"))
+		})
+	}
+}
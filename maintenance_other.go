@@ -0,0 +1,7 @@
+//go:build !unix
+
+package main
+
+// WatchMaintenanceSignal is a no-op where there's no SIGUSR2, the admin
+// endpoint still toggles maintenance mode
+func WatchMaintenanceSignal(m *Maintenance) {}
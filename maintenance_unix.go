@@ -0,0 +1,21 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// WatchMaintenanceSignal toggles maintenance mode on every SIGUSR2
+func WatchMaintenanceSignal(m *Maintenance) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR2)
+
+	go func() {
+		for range c {
+			m.Toggle("SIGUSR2")
+		}
+	}()
+}
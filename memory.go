@@ -0,0 +1,69 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// MemoryGuard tracks whether the heap in use is over a limit. Reading the
+// memory stats briefly stops the world, so it's sampled at most once per
+// interval by whichever request comes in first.
+type MemoryGuard struct {
+	limit    uint64
+	interval time.Duration
+
+	last atomic.Int64
+	over atomic.Bool
+}
+
+func NewMemoryGuard(limit uint64, interval time.Duration) *MemoryGuard {
+	return &MemoryGuard{limit: limit, interval: interval}
+}
+
+// Over samples the heap, if the last sample is older than the interval, and
+// reports whether it's over the limit, logging when that changes
+func (g *MemoryGuard) Over() bool {
+	now := time.Now().UnixNano()
+	last := g.last.Load()
+	if now-last < int64(g.interval) || !g.last.CompareAndSwap(last, now) {
+		return g.over.Load()
+	}
+
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+
+	over := stats.HeapInuse > g.limit
+	if g.over.Swap(over) != over {
+		if over {
+			log.Printf("| Shedding load, heap in use %d over %d bytes", stats.HeapInuse, g.limit)
+		} else {
+			log.Printf("| Stopped shedding load, heap in use %d bytes", stats.HeapInuse)
+		}
+	}
+	return over
+}
+
+// MemoryGuardMiddleware answers new requests, health checks aside, with a 503
+// while the heap in use is over limit bytes. A limit of 0 disables it.
+func MemoryGuardMiddleware(limit uint64) mux.MiddlewareFunc {
+	g := NewMemoryGuard(limit, time.Second)
+
+	return func(next http.Handler) http.Handler {
+		if limit == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if IsHealthCheck(r) || !g.Over() {
+				next.ServeHTTP(w, r)
+				return
+			}
+			SetRetryAfter(w.Header(), g.interval)
+			Reject(w, r, http.StatusServiceUnavailable, "memory pressure")
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const MethodOverrideHeader string = "X-HTTP-Method-Override"
+
+// MethodOverrideMiddleware routes POST requests as the method named by
+// X-HTTP-Method-Override, for clients that can only send GET and POST. Only
+// methods in allowed are honored, others are routed as the POST they are.
+// It changes what routes match, so it's opt-in, and does nothing without
+// allowed methods.
+func MethodOverrideMiddleware(allowed ...string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		if len(allowed) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			override := strings.ToUpper(strings.TrimSpace(r.Header.Get(MethodOverrideHeader)))
+			if r.Method != http.MethodPost || override == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			for _, method := range allowed {
+				if strings.EqualFold(override, method) {
+					LoggerFromContext(r).Printf("method overridden from %s to %s", r.Method, override)
+					r = r.WithContext(r.Context())
+					r.Method = override
+					break
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+type metric interface {
+	writeTo(w io.Writer)
+}
+
+// Registry exposes its metrics in the Prometheus text format. A nil Registry,
+// when metrics are disabled, hands out nil metrics whose methods are no-ops.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+var (
+	metrics         *Registry
+	panicsTotal     *CounterVec
+	logLinesDropped *CounterVec
+	requestSize     *HistogramVec
+	responseSize    *HistogramVec
+	requestsShed    *CounterVec
+)
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func SetupMetrics(cfg *Config) {
+	if cfg.Metrics {
+		metrics = NewRegistry()
+	}
+	panicsTotal = metrics.NewCounterVec(
+		"http_panics_total", "Panics recovered from handlers.", "route",
+	)
+	logLinesDropped = metrics.NewCounterVec(
+		"log_lines_dropped_total", "Log lines dropped as the async log queue was full.", "log",
+	)
+
+	requestsShed = metrics.NewCounterVec(
+		"http_requests_shed_total", "Requests refused over the request budget, reads or writes.", "kind",
+	)
+
+	sizeBuckets := make([]float64, len(cfg.SizeBuckets))
+	for i, size := range cfg.SizeBuckets {
+		sizeBuckets[i] = float64(size)
+	}
+	requestSize = metrics.NewHistogramVec(
+		"http_request_size_bytes", "Request body bytes read by handlers.", sizeBuckets, "route",
+	)
+	responseSize = metrics.NewHistogramVec(
+		"http_response_size_bytes", "Response body bytes written.", sizeBuckets, "route",
+	)
+}
+
+func (reg *Registry) register(m metric) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.metrics = append(reg.metrics, m)
+}
+
+func (reg *Registry) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		reg.mu.Lock()
+		defer reg.mu.Unlock()
+		for _, m := range reg.metrics {
+			m.writeTo(w)
+		}
+	}
+}
+
+var labelEscaper *strings.Replacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + labelEscaper.Replace(values[i]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func (reg *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	if reg == nil {
+		return nil
+	}
+	c := &CounterVec{name: name, help: help, labels: labels, values: map[string]float64{}}
+	reg.register(c)
+	return c
+}
+
+func (c *CounterVec) Add(value float64, labelValues ...string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[formatLabels(c.labels, labelValues)] += value
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Value is mostly useful to tests and debug endpoints
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[formatLabels(c.labels, labelValues)]
+}
+
+func (c *CounterVec) writeTo(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %g\n", c.name, key, c.values[key])
+	}
+}
+
+type histogramSeries struct {
+	labelValues []string
+	counts      []uint64
+	sum         float64
+	count       uint64
+}
+
+// HistogramVec counts observations in buckets by their upper bound, each
+// one counted in its own bucket only and summed up when written
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+// NewHistogramVec counts observations in buckets, sorted upper bounds to
+// which +Inf is added
+func (reg *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if reg == nil {
+		return nil
+	}
+	h := &HistogramVec{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		series:  map[string]*histogramSeries{},
+	}
+	reg.register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := formatLabels(h.labels, labelValues)
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{
+			labelValues: append([]string{}, labelValues...),
+			counts:      make([]uint64, len(h.buckets)+1),
+		}
+		h.series[key] = s
+	}
+	s.counts[sort.SearchFloat64s(h.buckets, value)]++
+	s.sum += value
+	s.count++
+}
+
+// Count is mostly useful to tests and debug endpoints
+func (h *HistogramVec) Count(labelValues ...string) (count uint64, sum float64) {
+	if h == nil {
+		return 0, 0
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s, ok := h.series[formatLabels(h.labels, labelValues)]; ok {
+		return s.count, s.sum
+	}
+	return 0, 0
+}
+
+func (h *HistogramVec) writeTo(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	names := append(append([]string{}, h.labels...), "le")
+	for _, key := range keys {
+		s := h.series[key]
+		values := append(append([]string{}, s.labelValues...), "")
+		var cumulative uint64
+		for i, count := range s.counts {
+			cumulative += count
+			values[len(values)-1] = "+Inf"
+			if i < len(h.buckets) {
+				values[len(values)-1] = strconv.FormatFloat(h.buckets[i], 'g', -1, 64)
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(names, values), cumulative)
+		}
+		fmt.Fprintf(w, "%s_sum%s %g\n", h.name, key, s.sum)
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, key, s.count)
+	}
+}
+
+// RouteName is the matched route's name, the label metrics are split by
+func RouteName(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil && route.GetName() != "" {
+		return route.GetName()
+	}
+	return "none"
+}
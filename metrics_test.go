@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// enableMetrics sets the metrics up as main does with METRICS, disabling
+// them again once the test is done
+func enableMetrics(t *testing.T) {
+	t.Helper()
+	t.Cleanup(func() {
+		metrics = nil
+		SetupMetrics(DefaultConfig())
+	})
+	cfg := DefaultConfig()
+	cfg.Metrics = true
+	SetupMetrics(cfg)
+}
+
+// scrape serves the registry's /metrics
+func scrape(t *testing.T) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	return w.Body.String()
+}
+
+func TestPanicCounter(t *testing.T) {
+	enableMetrics(t)
+	captureLog(t, accessLog)
+	captureLog(t, errorLog)
+
+	router := chainRouter()
+	for i := 0; i < 2; i++ {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/nil_pointer", nil))
+	}
+
+	if got := panicsTotal.Value("nil_pointer"); got != 2 {
+		t.Errorf("got %v panics, want 2", got)
+	}
+	if body := scrape(t); !strings.Contains(body, `http_panics_total{route="nil_pointer"} 2`) {
+		t.Errorf("panics missing from /metrics:\n%s", body)
+	}
+}
+
+func TestHistogramVecBuckets(t *testing.T) {
+	reg := NewRegistry()
+	h := reg.NewHistogramVec("size_bytes", "Sizes.", []float64{10, 100}, "route")
+	// On a bound counts in its bucket, le being less or equal
+	for _, v := range []float64{0, 10, 11, 100, 101} {
+		h.Observe(v, "a")
+	}
+	h.Observe(5, `b"`)
+
+	if count, sum := h.Count("a"); count != 5 || sum != 222 {
+		t.Errorf("got count %d and sum %v, want 5 and 222", count, sum)
+	}
+	w := httptest.NewRecorder()
+	reg.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	want := `# HELP size_bytes Sizes.
+# TYPE size_bytes histogram
+size_bytes_bucket{route="a",le="10"} 2
+size_bytes_bucket{route="a",le="100"} 4
+size_bytes_bucket{route="a",le="+Inf"} 5
+size_bytes_sum{route="a"} 222
+size_bytes_count{route="a"} 5
+size_bytes_bucket{route="b\"",le="10"} 1
+size_bytes_bucket{route="b\"",le="100"} 1
+size_bytes_bucket{route="b\"",le="+Inf"} 1
+size_bytes_sum{route="b\""} 5
+size_bytes_count{route="b\""} 1
+`
+	if got := w.Body.String(); got != want {
+		t.Errorf("got exposition:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestResponseSizeHistogram(t *testing.T) {
+	enableMetrics(t)
+	captureLog(t, accessLog)
+
+	router := chainRouter()
+	Handle(router, "body", "/body", []string{"GET"}, func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, 300))
+	})
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/body", nil))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ok", nil))
+
+	if count, sum := responseSize.Count("body"); count != 1 || sum != 300 {
+		t.Errorf("got count %d and sum %v for body, want 1 and 300", count, sum)
+	}
+	body := scrape(t)
+	for _, line := range []string{
+		`http_response_size_bytes_bucket{route="body",le="256"} 0`,
+		`http_response_size_bytes_bucket{route="body",le="1024"} 1`,
+		`http_response_size_bytes_sum{route="body"} 300`,
+		`http_response_size_bytes_bucket{route="ok",le="256"} 1`,
+		`http_response_size_bytes_count{route="ok"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("%s missing from /metrics:\n%s", line, body)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Priorities of the in-route middlewares, lowest outermost:
+//
+//   - recovery wraps everything, so a panic anywhere below becomes a 500
+//   - logging sees the final status, including the route switch's 503s
+//   - dry runs answer once logged, before anything else could act
+//   - chaos delays and failures are logged like real ones
+//   - the route switch refuses disabled routes before any work is done
+//   - HMAC signatures are verified before CSRF, which signed requests skip
+//   - CSRF tokens are checked before the request has any effect
+//   - hop-by-hop headers are stripped from the request the inner ones see
+//     and from whatever they send
+//   - the write deadline caps every handler's context below WriteTimeout
+//   - the watchdog bounds the handler along with its compression
+//   - gzip compresses exactly what the handler writes
+//   - single flight shares responses before they're compressed for each
+//     client
+//   - status rewrites look at the body before it's compressed
+//   - default headers are set before gzip sniffs a missing Content-Type
+//   - the Content-Type check sees what the handler left out, route
+//     defaults aside
+//   - the safe method check sees the cookies the handler itself sets
+//   - the cancel probe sits right on the handler, timing its own return
+const (
+	PriorityRecovery      int = 0
+	PriorityLogger        int = 10
+	PriorityDryRun        int = 15
+	PriorityChaos         int = 17
+	PriorityRouteSwitch   int = 20
+	PriorityHMAC          int = 24
+	PriorityCSRF          int = 25
+	PriorityHopByHop      int = 30
+	PriorityWriteDeadline int = 35
+	PriorityWatchdog      int = 40
+	PriorityGzip          int = 50
+	PrioritySingleFlight  int = 52
+	PriorityStatusRewrite int = 55
+	PriorityRouteHeaders  int = 57
+	PriorityContentType   int = 58
+	PrioritySafeMethod    int = 59
+	PriorityCancelProbe   int = 60
+)
+
+type middlewareEntry struct {
+	priority int
+	name     string
+	mw       mux.MiddlewareFunc
+}
+
+// Middlewares composes middlewares by priority rather than by the order they
+// are added in, ties keeping that order.
+type Middlewares struct {
+	entries []middlewareEntry
+	slow    time.Duration
+}
+
+func (m *Middlewares) Add(priority int, name string, mw mux.MiddlewareFunc) {
+	m.entries = append(m.entries, middlewareEntry{priority, name, mw})
+}
+
+func (m *Middlewares) sorted() []middlewareEntry {
+	entries := append([]middlewareEntry{}, m.entries...)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].priority < entries[j].priority
+	})
+	return entries
+}
+
+// Names lists the middlewares outermost first
+func (m *Middlewares) Names() []string {
+	names := []string{}
+	for _, e := range m.sorted() {
+		names = append(names, e.name)
+	}
+	return names
+}
+
+// Ordered lists the middlewares outermost first, as router.Use takes them,
+// each one timed when TimeSlowRequests is set
+func (m *Middlewares) Ordered() []mux.MiddlewareFunc {
+	entries := m.sorted()
+	names := []string{}
+	for _, e := range entries {
+		names = append(names, e.name)
+	}
+	names = append(names, "handler")
+
+	mws := []mux.MiddlewareFunc{}
+	for i, e := range entries {
+		mw := e.mw
+		if m.slow > 0 {
+			mw = m.timed(i, len(entries), names, e.mw)
+		}
+		mws = append(mws, mw)
+	}
+	return mws
+}
+
+// TimeSlowRequests has requests taking slow or longer log how long each
+// middleware took of it. Left at 0, the middlewares aren't timed at all.
+func (m *Middlewares) TimeSlowRequests(slow time.Duration) {
+	m.slow = slow
+}
+
+// middlewareTimings are how long each layer of a request's chain took, its
+// inner ones included, indexed like their names
+type middlewareTimings struct {
+	names  []string
+	totals []atomic.Int64
+}
+
+// breakdown is each layer's own share, a layer's total less the next one's.
+// Layers taking under a microsecond of their own, like disabled
+// middlewares, are left out.
+func (mt *middlewareTimings) breakdown() string {
+	parts := []string{}
+	for i := range mt.totals {
+		own := time.Duration(mt.totals[i].Load())
+		if i+1 < len(mt.totals) {
+			own -= time.Duration(mt.totals[i+1].Load())
+		}
+		if own >= time.Microsecond {
+			parts = append(parts, mt.names[i]+" "+own.String())
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// timed wraps the middleware at index i of n, the last one also timing the
+// route's handler. The outermost one starts the request's timings and logs
+// them when slow.
+func (m *Middlewares) timed(i, n int, names []string, mw mux.MiddlewareFunc) mux.MiddlewareFunc {
+	layer := func(index int, h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mt, ok := r.Context().Value(timingsKey).(*middlewareTimings)
+			if !ok {
+				mt = &middlewareTimings{names: names, totals: make([]atomic.Int64, n+1)}
+				r = r.WithContext(context.WithValue(r.Context(), timingsKey, mt))
+			}
+
+			start := time.Now()
+			defer func() {
+				took := time.Since(start)
+				mt.totals[index].Store(int64(took))
+				if index == 0 && took >= m.slow {
+					LoggerFromContext(r).Printf("slow request, %s: %s", took, mt.breakdown())
+				}
+			}()
+			h.ServeHTTP(w, r)
+		})
+	}
+
+	return func(next http.Handler) http.Handler {
+		if i == n-1 {
+			next = layer(n, next)
+		}
+		return layer(i, mw(next))
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestMiddlewaresNames(t *testing.T) {
+	var ran []string
+	tracing := func(name string) mux.MiddlewareFunc {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ran = append(ran, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	m := &Middlewares{}
+	m.Add(PriorityGzip, "gzip", tracing("gzip"))
+	m.Add(PriorityLogger, "logger", tracing("logger"))
+	m.Add(PriorityHMAC, "hmac", tracing("hmac"))
+	m.Add(PriorityRecovery, "recovery", tracing("recovery"))
+	// Ties keep the order they were added in
+	m.Add(PriorityHMAC, "hmac_2", tracing("hmac_2"))
+
+	want := []string{"recovery", "logger", "hmac", "hmac_2", "gzip"}
+	if got := m.Names(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got names %v, want %v", got, want)
+	}
+	if got := (&Middlewares{}).Names(); len(got) != 0 {
+		t.Errorf("got names %v with no middlewares", got)
+	}
+
+	router := mux.NewRouter()
+	router.Use(m.Ordered()...)
+	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {})
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if !reflect.DeepEqual(ran, want) {
+		t.Errorf("ran %v, want %v", ran, want)
+	}
+}
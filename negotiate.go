@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+func parseAccept(header string) []acceptRange {
+	ranges := []acceptRange{}
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+		if mediaType == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range params[1:] {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(key, "q") {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		ranges = append(ranges, acceptRange{mediaType, q})
+	}
+	return ranges
+}
+
+// acceptQuality is the q of the most specific range matching offer, exact
+// types beating type/* beating */*, or -1 when none matches.
+func acceptQuality(ranges []acceptRange, offer string) float64 {
+	var (
+		q           float64 = -1
+		specificity int     = -1
+	)
+	offerType, _, _ := strings.Cut(offer, "/")
+
+	for _, ar := range ranges {
+		rangeType, rangeSubtype, _ := strings.Cut(ar.mediaType, "/")
+		s := -1
+		switch {
+		case ar.mediaType == offer:
+			s = 2
+		case rangeType == offerType && rangeSubtype == "*":
+			s = 1
+		case ar.mediaType == "*/*":
+			s = 0
+		}
+		if s > specificity {
+			q, specificity = ar.q, s
+		}
+	}
+	return q
+}
+
+// Negotiate picks the supported type the Accept header prefers, the first
+// supported one winning ties. A missing Accept accepts anything.
+func Negotiate(accept string, supported ...string) (string, bool) {
+	if len(supported) == 0 {
+		return "", false
+	}
+	if strings.TrimSpace(accept) == "" {
+		return supported[0], true
+	}
+
+	var (
+		ranges []acceptRange = parseAccept(accept)
+		best   string
+		bestQ  float64
+	)
+	for _, offer := range supported {
+		if q := acceptQuality(ranges, strings.ToLower(offer)); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best, best != ""
+}
+
+func AcceptedTypeFromContext(r *http.Request) string {
+	t, _ := r.Context().Value(acceptedTypeKey).(string)
+	return t
+}
+
+// NegotiateMiddleware answers 406 when the client accepts none of supported,
+// otherwise the chosen type is available through AcceptedTypeFromContext.
+func NegotiateMiddleware(supported ...string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			accepted, ok := Negotiate(r.Header.Get("Accept"), supported...)
+			if !ok {
+				writeError(w, r, http.StatusNotAcceptable, http.StatusText(http.StatusNotAcceptable))
+				return
+			}
+			ctx := context.WithValue(r.Context(), acceptedTypeKey, accepted)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
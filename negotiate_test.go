@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateNotAcceptable(t *testing.T) {
+	handler := NegotiateMiddleware("application/json")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(AcceptedTypeFromContext(r)))
+	}))
+
+	tests := []struct {
+		accept      string
+		status      int
+		contentType string
+	}{
+		{"application/json", http.StatusOK, ""},
+		{"image/png", http.StatusNotAcceptable, "text/plain"},
+		{ProblemContentType, http.StatusNotAcceptable, ProblemContentType},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/items", nil)
+		r.Header.Set("Accept", tt.accept)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != tt.status {
+			t.Errorf("Accept %s: got %d, want %d", tt.accept, w.Code, tt.status)
+		}
+		if tt.contentType != "" && !strings.HasPrefix(w.Header().Get("Content-Type"), tt.contentType) {
+			t.Errorf("Accept %s: got Content-Type %q, want %s", tt.accept, w.Header().Get("Content-Type"), tt.contentType)
+		}
+	}
+}
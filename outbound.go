@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
+)
+
+// outboundPhases are the timings of one outbound call's connection, each
+// zero when the phase didn't happen, like dialing over a reused one
+type outboundPhases struct {
+	mu sync.Mutex
+
+	start                   time.Time
+	dnsStart, connectStart  time.Time
+	tlsStart, wroteRequest  time.Time
+	dns, connect, tls, ttfb time.Duration
+	reused                  bool
+}
+
+func (p *outboundPhases) trace() *httptrace.ClientTrace {
+	// Hooks may run on the transport's goroutines, like the dialer's racing
+	// IPv4 and IPv6
+	locked := func(f func()) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		f()
+	}
+	return &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			locked(func() { p.reused = info.Reused })
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			locked(func() { p.dnsStart = time.Now() })
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			locked(func() { p.dns = time.Since(p.dnsStart) })
+		},
+		ConnectStart: func(string, string) {
+			locked(func() {
+				if p.connectStart.IsZero() {
+					p.connectStart = time.Now()
+				}
+			})
+		},
+		ConnectDone: func(string, string, error) {
+			locked(func() { p.connect = time.Since(p.connectStart) })
+		},
+		TLSHandshakeStart: func() {
+			locked(func() { p.tlsStart = time.Now() })
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			locked(func() { p.tls = time.Since(p.tlsStart) })
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			locked(func() { p.wroteRequest = time.Now() })
+		},
+		GotFirstResponseByte: func() {
+			locked(func() { p.ttfb = time.Since(p.wroteRequest) })
+		},
+	}
+}
+
+func (p *outboundPhases) String() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	phases := []string{}
+	if p.reused {
+		phases = append(phases, "reused connection")
+	}
+	for _, phase := range []struct {
+		name string
+		d    time.Duration
+	}{{"dns", p.dns}, {"connect", p.connect}, {"tls", p.tls}, {"ttfb", p.ttfb}} {
+		if phase.d > 0 {
+			phases = append(phases, phase.name+" "+phase.d.String())
+		}
+	}
+	return strings.Join(phases, ", ")
+}
+
+// tracingTransport logs the phases of each call made on behalf of r
+type tracingTransport struct {
+	base http.RoundTripper
+	r    *http.Request
+}
+
+func (t *tracingTransport) RoundTrip(out *http.Request) (*http.Response, error) {
+	phases := &outboundPhases{start: time.Now()}
+	out = out.Clone(httptrace.WithClientTrace(out.Context(), phases.trace()))
+	if out.Header.Get(RequestIDHeader) == "" {
+		out.Header.Set(RequestIDHeader, RequestIDFromContext(t.r))
+	}
+
+	res, err := t.base.RoundTrip(out)
+
+	// Without the query, which may carry credentials
+	target := out.URL.Scheme + "://" + out.URL.Host + out.URL.Path
+	logger := LoggerFromContext(t.r)
+	if err != nil {
+		logger.Printf("outbound %s %s failed after %s: %v (%s)", out.Method, target, time.Since(phases.start), err, phases)
+		return nil, err
+	}
+	logger.Printf("outbound %s %s %d in %s: %s", out.Method, target, res.StatusCode, time.Since(phases.start), phases)
+	return res, nil
+}
+
+// OutboundClient is base, http.DefaultClient when nil, logging the DNS,
+// connect, TLS and time to first byte of every call with r's logger, under
+// its request ID, which the calls also pass on in X-Request-ID unless they
+// set their own. Handlers opt in by making their outbound calls through it,
+// typically a client per request sharing the transport of a long lived one:
+//
+//	res, err := OutboundClient(r, upstream).Get(url)
+func OutboundClient(r *http.Request, base *http.Client) *http.Client {
+	if base == nil {
+		base = http.DefaultClient
+	}
+	transport := base.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	client := *base
+	client.Transport = &tracingTransport{base: transport, r: r}
+	return &client
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime/debug"
+)
+
+// panicCause is one error of a recovered panic's chain
+type panicCause struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// panicType is the concrete Go type of a recovered value, like
+// runtime.boundsError or string
+func panicType(v interface{}) string {
+	return fmt.Sprintf("%T", v)
+}
+
+// panicChain follows errors.Unwrap from a recovered error down to its root
+// cause, and into each of the errors joined by errors.Join, nil when the
+// value isn't an error
+func panicChain(v interface{}) []panicCause {
+	err, ok := v.(error)
+	if !ok {
+		return nil
+	}
+	return appendCauses([]panicCause{}, err)
+}
+
+func appendCauses(chain []panicCause, err error) []panicCause {
+	for ; err != nil; err = errors.Unwrap(err) {
+		chain = append(chain, panicCause{panicType(err), err.Error()})
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			for _, e := range joined.Unwrap() {
+				chain = appendCauses(chain, e)
+			}
+			return chain
+		}
+	}
+	return chain
+}
+
+// reraisedPanic carries a panic recovered on another goroutine, like the
+// watchdog's, along with the stack it was recovered on, so it's told as the
+// handler's and not the re-raising goroutine's
+type reraisedPanic struct {
+	value interface{}
+	stack []byte
+}
+
+// reraise panics again with v, recovered on the handler's goroutine from
+// which stack was taken. http.ErrAbortHandler goes as is, for net/http.
+func reraise(v interface{}, stack []byte) {
+	if v == http.ErrAbortHandler {
+		panic(v)
+	}
+	panic(&reraisedPanic{value: v, stack: stack})
+}
+
+// unwrapPanic is v's value and the stack it was first recovered on when it
+// was re-raised, a nil stack otherwise
+func unwrapPanic(v interface{}) (interface{}, []byte) {
+	if rp, ok := v.(*reraisedPanic); ok {
+		return rp.value, rp.stack
+	}
+	return v, nil
+}
+
+// panicWriter tells RecoveryMiddleware whether the status was already sent
+type panicWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (pw *panicWriter) WriteHeader(status int) {
+	if status >= 200 {
+		pw.wroteHeader = true
+	}
+	pw.ResponseWriter.WriteHeader(status)
+}
+
+func (pw *panicWriter) Write(b []byte) (int, error) {
+	pw.wroteHeader = true
+	return pw.ResponseWriter.Write(b)
+}
+
+func (pw *panicWriter) Flush() {
+	pw.wroteHeader = true
+	if f, ok := pw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (pw *panicWriter) Unwrap() http.ResponseWriter {
+	return pw.ResponseWriter
+}
+
+// CrashMiddleware takes RecoveryMiddleware's place with RECOVER=0. net/http
+// would recover a handler panic itself and keep serving, so it's turned
+// into a crash printing the panicking goroutine's stack, to debug it like any
+// other panic, the handler's own under the watchdog too.
+func CrashMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				// Sent on purpose to abort a response, not a bug
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+				err, stack := unwrapPanic(err)
+				if stack == nil {
+					stack = debug.Stack()
+				}
+				fmt.Fprintf(os.Stderr, "panic: %v\n\n%s", err, stack)
+				os.Exit(2)
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
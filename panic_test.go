@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPanicChain(t *testing.T) {
+	notExist := &fs.PathError{Op: "open", Path: "config.json", Err: fs.ErrNotExist}
+
+	tests := []struct {
+		name  string
+		value interface{}
+		want  []panicCause
+	}{
+		{
+			name:  "wrapped",
+			value: fmt.Errorf("loading config: %w", notExist),
+			want: []panicCause{
+				{"*fmt.wrapError", "loading config: open config.json: file does not exist"},
+				{"*fs.PathError", "open config.json: file does not exist"},
+				{"*errors.errorString", "file does not exist"},
+			},
+		},
+		{
+			name:  "joined",
+			value: errors.Join(errors.New("first"), fmt.Errorf("second: %w", fs.ErrClosed)),
+			want: []panicCause{
+				{"*errors.joinError", "first\nsecond: file already closed"},
+				{"*errors.errorString", "first"},
+				{"*fmt.wrapError", "second: file already closed"},
+				{"*errors.errorString", "file already closed"},
+			},
+		},
+		{
+			name:  "not an error",
+			value: "just a string",
+			want:  nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := panicChain(tt.value); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if got := panicType("just a string"); got != "string" {
+		t.Errorf("got type %q of a string panic, want string", got)
+	}
+}
+
+// captureStdLog points the log package at a buffer for the rest of the test
+func captureStdLog(t *testing.T) *capturedLog {
+	t.Helper()
+	out := log.Writer()
+	t.Cleanup(func() { log.SetOutput(out) })
+	c := &capturedLog{}
+	log.SetOutput(&c.buf)
+	return c
+}
+
+// headerCounter counts the statuses written, panicking on them with panics
+type headerCounter struct {
+	*httptest.ResponseRecorder
+	headers int
+	panics  bool
+}
+
+func (h *headerCounter) WriteHeader(status int) {
+	h.headers++
+	if h.panics {
+		panic("broken writer")
+	}
+	h.ResponseRecorder.WriteHeader(status)
+}
+
+func TestRecoveryPanicWhileAnswering(t *testing.T) {
+	errs := captureLog(t, errorLog)
+	std := captureStdLog(t)
+
+	w := &headerCounter{ResponseRecorder: httptest.NewRecorder(), panics: true}
+	h := RecoveryMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("handler failed")
+	}))
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if w.headers != 1 {
+		t.Errorf("got %d statuses written, want 1", w.headers)
+	}
+	if lines := errs.Lines(t); len(lines) != 1 || lines[0]["message"] != "handler failed" {
+		t.Errorf("got error lines %v, want the handler's panic", lines)
+	}
+	if got := std.String(); !strings.Contains(got, `panic "broken writer" while answering the 500 of panic "handler failed"`) {
+		t.Errorf("got %q, want both panics logged", got)
+	}
+}
+
+func TestRecoveryDeferredPanic(t *testing.T) {
+	errs := captureLog(t, errorLog)
+
+	w := &headerCounter{ResponseRecorder: httptest.NewRecorder()}
+	h := RecoveryMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() { panic("cleanup failed") }()
+		panic("handler failed")
+	}))
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if w.headers != 1 || w.Code != http.StatusInternalServerError {
+		t.Errorf("got %d statuses written, the last %d, want a single 500", w.headers, w.Code)
+	}
+	// recover() only gets the latest of the panics
+	if lines := errs.Lines(t); len(lines) != 1 || lines[0]["message"] != "cleanup failed" {
+		t.Errorf("got error lines %v, want the deferred panic", lines)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// VarValidator checks a path variable, its error explaining what's wrong
+type VarValidator func(value string) error
+
+// Numeric accepts unsigned decimal integers
+func Numeric(value string) error {
+	if _, err := strconv.ParseUint(value, 10, 64); err != nil {
+		return errors.New("must be numeric")
+	}
+	return nil
+}
+
+// ValidateVarsMiddleware checks mux.Vars(r) against validators before the
+// handler runs, answering a 400 JSON error listing every invalid variable
+// along with why. Variables without a validator aren't checked.
+func ValidateVarsMiddleware(validators map[string]VarValidator) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			vars := mux.Vars(r)
+			invalid := map[string]string{}
+			for name, validate := range validators {
+				if value, ok := vars[name]; ok {
+					if err := validate(value); err != nil {
+						invalid[name] = err.Error()
+					}
+				}
+			}
+			if len(invalid) > 0 {
+				writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+					"error": "invalid path variables",
+					"vars":  invalid,
+				})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
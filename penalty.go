@@ -0,0 +1,158 @@
+package main
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type penaltyScore struct {
+	errors    float64
+	last      time.Time
+	penalized bool
+}
+
+// ErrorPenalties keeps a score of each client's 4xx, decaying over time,
+// and holds clients scoring threshold or more to a rate limit of their own
+// until they fall back under half of it. A nil *ErrorPenalties penalizes
+// nobody.
+type ErrorPenalties struct {
+	threshold float64
+	decay     time.Duration
+	limiter   *RateLimiter
+
+	mu      sync.Mutex
+	clients map[string]*penaltyScore
+}
+
+var errorPenalties *ErrorPenalties
+
+// NewErrorPenalties penalizes clients with threshold 4xx or more within
+// about decay, the score losing two thirds of itself every decay, nil when
+// threshold is 0
+func NewErrorPenalties(threshold int, decay time.Duration, limit RateLimit) *ErrorPenalties {
+	if threshold <= 0 || decay <= 0 || limit.Unlimited() {
+		return nil
+	}
+	return &ErrorPenalties{
+		threshold: float64(threshold),
+		decay:     decay,
+		limiter:   NewRateLimiter(limit, nil),
+		clients:   map[string]*penaltyScore{},
+	}
+}
+
+// penaltyStatus tells the client errors counted against clients: the 429s
+// of their own throttling aren't, nor are their cancelled requests
+func penaltyStatus(status int) bool {
+	return status >= 400 && status < 500 &&
+		status != http.StatusTooManyRequests && status != StatusClientClosedRequest
+}
+
+// decayed brings s's score to now, logging the client leaving the penalty
+func (p *ErrorPenalties) decayed(client string, s *penaltyScore, now time.Time) {
+	s.errors *= math.Exp(-now.Sub(s.last).Seconds() / p.decay.Seconds())
+	s.last = now
+	if s.penalized && s.errors < p.threshold/2 {
+		s.penalized = false
+		log.Println("| Client " + client + " out of the error penalty")
+	}
+}
+
+// Observe counts status against the client, from the access log's status
+func (p *ErrorPenalties) Observe(client string, status int) {
+	if p == nil || !penaltyStatus(status) {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	s, ok := p.clients[client]
+	if !ok {
+		if len(p.clients) >= rateLimitClients {
+			p.dropForgiven(now)
+		}
+		s = &penaltyScore{last: now}
+		p.clients[client] = s
+	}
+	p.decayed(client, s, now)
+	s.errors++
+
+	if !s.penalized && s.errors >= p.threshold {
+		s.penalized = true
+		log.Println(
+			"| Client " + client + " penalized after " + strconv.Itoa(int(s.errors)) +
+				" client errors, limited to " + p.limiter.global.String(),
+		)
+	}
+}
+
+// dropForgiven forgets the clients whose score decayed to nearly nothing,
+// everything when too many still score
+func (p *ErrorPenalties) dropForgiven(now time.Time) {
+	for client, s := range p.clients {
+		p.decayed(client, s, now)
+		if !s.penalized && s.errors < 1 {
+			delete(p.clients, client)
+		}
+	}
+	if len(p.clients) >= rateLimitClients {
+		p.clients = map[string]*penaltyScore{}
+	}
+}
+
+// Penalized reports whether client is being penalized
+func (p *ErrorPenalties) Penalized(client string) bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s, ok := p.clients[client]
+	if !ok {
+		return false
+	}
+	p.decayed(client, s, time.Now())
+	return s.penalized
+}
+
+// Allow takes a token from a penalized client's bucket, always allowing
+// the others
+func (p *ErrorPenalties) Allow(client string, now time.Time) (bool, time.Duration) {
+	if !p.Penalized(client) {
+		return true, 0
+	}
+	return p.limiter.Allow("", client, now)
+}
+
+// ErrorPenaltyMiddleware answers a 429 with a Retry-After to penalized
+// clients over their penalty rate. It runs before routing, so the 404s of
+// scans are held back along with the rest, and before the rate limit
+// counts them. Clients are told apart by IP, health checks never limited.
+func ErrorPenaltyMiddleware(p *ErrorPenalties) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		if p == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if IsHealthCheck(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ok, wait := p.Allow(RateLimitByIP(r), time.Now())
+			if ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			SetRetryAfter(w.Header(), wait)
+			Reject(w, r, http.StatusTooManyRequests, "held to the error penalty rate")
+		})
+	}
+}
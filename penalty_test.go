@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestErrorPenaltyMiddleware(t *testing.T) {
+	captureStdLog(t)
+	captureLog(t, accessLog)
+
+	p := NewErrorPenalties(3, time.Minute, RateLimit{Rate: 0.001, Burst: 1})
+	handler := ErrorPenaltyMiddleware(p)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+
+	serve := func(remote string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/nope", nil)
+		r.RemoteAddr = remote
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		p.Observe(RateLimitByIP(r), w.Code)
+		return w
+	}
+
+	// The score decays a little between the first errors, penalized from
+	// the fourth, and the penalty's burst lets one more through
+	for i := 0; i < 5; i++ {
+		if w := serve("192.0.2.1:1234"); w.Code != http.StatusNotFound {
+			t.Fatalf("request %d: got %d, want 404", i, w.Code)
+		}
+	}
+	w := serve("192.0.2.1:1234")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("penalized client: got %d, want 429", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("penalized client: no Retry-After")
+	}
+	if w := serve("192.0.2.2:1234"); w.Code != http.StatusNotFound {
+		t.Errorf("other client: got %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+const ProblemContentType string = "application/problem+json"
+
+// Problem is an RFC 7807 problem details object
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// AcceptsProblem reports whether the client asked for problem details by
+// name. Wildcards don't count, so browsers and curl keep plain responses.
+func AcceptsProblem(r *http.Request) bool {
+	for _, ar := range parseAccept(r.Header.Get("Accept")) {
+		if ar.mediaType == ProblemContentType && ar.q > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteProblem answers with p, defaulting its type to about:blank, its title
+// to the status text and its instance to the request path
+func WriteProblem(w http.ResponseWriter, r *http.Request, p Problem) {
+	if p.Type == "" {
+		p.Type = "about:blank"
+	}
+	if p.Title == "" {
+		p.Title = http.StatusText(p.Status)
+	}
+	if p.Instance == "" {
+		p.Instance = r.URL.Path
+	}
+
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// writeError answers status as problem details to clients asking for them,
+// and like http.Error with the plain message otherwise
+func writeError(w http.ResponseWriter, r *http.Request, status int, plain string) {
+	if AcceptsProblem(r) {
+		WriteProblem(w, r, Problem{Status: status})
+		return
+	}
+	http.Error(w, plain, status)
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// rateLimitClients bounds the buckets kept, idle ones are dropped past it
+const rateLimitClients int = 10000
+
+// RateLimit allows Rate requests a second with bursts of Burst. The zero
+// RateLimit is unlimited. It reads as "10/s", "600/m" or "3600/h", with an
+// optional ":burst" suffix defaulting to a second's worth, or "unlimited".
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+func (l RateLimit) Unlimited() bool { return l.Rate <= 0 }
+
+func ParseRateLimit(v string) (RateLimit, error) {
+	v = strings.TrimSpace(v)
+	if v == "" || v == "unlimited" {
+		return RateLimit{}, nil
+	}
+
+	rate, burst, hasBurst := strings.Cut(v, ":")
+	count, unit, ok := strings.Cut(rate, "/")
+	if !ok {
+		return RateLimit{}, fmt.Errorf("expected a rate like 10/s, got %q", v)
+	}
+	n, err := strconv.ParseFloat(count, 64)
+	if err != nil || n <= 0 {
+		return RateLimit{}, fmt.Errorf("invalid rate %q", v)
+	}
+
+	var l RateLimit
+	switch unit {
+	case "s":
+		l.Rate = n
+	case "m":
+		l.Rate = n / 60
+	case "h":
+		l.Rate = n / 3600
+	default:
+		return RateLimit{}, fmt.Errorf("unknown rate unit %q, expected s, m or h", unit)
+	}
+
+	l.Burst = int(math.Max(1, math.Ceil(l.Rate)))
+	if hasBurst {
+		if l.Burst, err = strconv.Atoi(burst); err != nil || l.Burst < 1 {
+			return RateLimit{}, fmt.Errorf("invalid burst in %q", v)
+		}
+	}
+	return l, nil
+}
+
+func (l *RateLimit) Set(v string) error {
+	parsed, err := ParseRateLimit(v)
+	if err != nil {
+		return err
+	}
+	*l = parsed
+	return nil
+}
+
+func (l *RateLimit) String() string {
+	if l.Unlimited() {
+		return "unlimited"
+	}
+	return strconv.FormatFloat(l.Rate, 'f', -1, 64) + "/s:" + strconv.Itoa(l.Burst)
+}
+
+func (l RateLimit) MarshalJSON() ([]byte, error) {
+	return json.Marshal(l.String())
+}
+
+func (l *RateLimit) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	return l.Set(s)
+}
+
+// RateLimits are per route name overrides, read as a comma separated list
+// of name=rate pairs
+type RateLimits map[string]RateLimit
+
+func (m *RateLimits) Set(v string) error {
+	limits := RateLimits{}
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return fmt.Errorf("expected name=rate, got %q", item)
+		}
+		var l RateLimit
+		if err := l.Set(value); err != nil {
+			return err
+		}
+		limits[strings.TrimSpace(name)] = l
+	}
+	*m = limits
+	return nil
+}
+
+func (m *RateLimits) String() string {
+	pairs := []string{}
+	for name, l := range *m {
+		pairs = append(pairs, name+"="+l.String())
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter keeps a token bucket per client and limit. A route's override,
+// "unlimited" included, takes precedence over the global limit, and gets
+// buckets of its own. Routes without one share the global buckets.
+type RateLimiter struct {
+	global RateLimit
+	routes RateLimits
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+func NewRateLimiter(global RateLimit, routes RateLimits) *RateLimiter {
+	return &RateLimiter{global: global, routes: routes, buckets: map[string]*bucket{}}
+}
+
+// Limit is the limit applying to route, and the scope its buckets are kept
+// under
+func (l *RateLimiter) Limit(route string) (RateLimit, string) {
+	if limit, ok := l.routes[route]; ok {
+		return limit, route
+	}
+	return l.global, ""
+}
+
+// Limited reports whether any route is limited at all
+func (l *RateLimiter) Limited() bool {
+	if !l.global.Unlimited() {
+		return true
+	}
+	for _, limit := range l.routes {
+		if !limit.Unlimited() {
+			return true
+		}
+	}
+	return false
+}
+
+// Allow takes a token from client's bucket for route, or tells how long
+// until one is available
+func (l *RateLimiter) Allow(route, client string, now time.Time) (bool, time.Duration) {
+	limit, scope := l.Limit(route)
+	if limit.Unlimited() {
+		return true, 0
+	}
+	key := scope + " " + client
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= rateLimitClients {
+			l.dropFull(now)
+		}
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+}
+
+// dropFull forgets the clients whose buckets refilled, they start full again
+// anyway. Everything goes when all of them are still busy.
+func (l *RateLimiter) dropFull(now time.Time) {
+	for key, b := range l.buckets {
+		scope, _, _ := strings.Cut(key, " ")
+		limit, _ := l.Limit(scope)
+		if b.tokens+now.Sub(b.last).Seconds()*limit.Rate >= float64(limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+	if len(l.buckets) >= rateLimitClients {
+		l.buckets = map[string]*bucket{}
+	}
+}
+
+// RateLimitKey tells which client a request counts against
+type RateLimitKey func(r *http.Request) string
+
+func RateLimitByIP(r *http.Request) string {
+	return "ip:" + ClientIP(r).String()
+}
+
+// RateLimitByUser counts requests against the user WithUser set, as many
+// may share an IP behind a NAT, and anonymous ones against their IP. Config
+// refuses it while no authentication middleware sets the user.
+func RateLimitByUser(r *http.Request) string {
+	if user := UserFromContext(r); user != "" {
+		return "user:" + user
+	}
+	return RateLimitByIP(r)
+}
+
+func ParseRateLimitKey(by string) (RateLimitKey, error) {
+	switch by {
+	case "", "ip":
+		return RateLimitByIP, nil
+	case "user":
+		return RateLimitByUser, nil
+	}
+	return nil, fmt.Errorf("unknown rate limit key %q, expected ip or user", by)
+}
+
+// matchedRouteName is the name of the route of router r will be served by,
+// "" when none matches, for middlewares running before routing
+func matchedRouteName(router *mux.Router, r *http.Request) string {
+	var match mux.RouteMatch
+	if router.Match(r, &match) && match.Route != nil {
+		return match.Route.GetName()
+	}
+	return ""
+}
+
+// RateLimitMiddleware answers a 429 with a Retry-After to clients, told
+// apart by key, going over the limit of the route router matches, the
+// global one for paths matching none. It runs before routing, so scans of
+// unknown paths are limited too. Health checks are never limited. It's a
+// no-op when nothing is.
+func RateLimitMiddleware(l *RateLimiter, key RateLimitKey, router *mux.Router) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		if !l.Limited() {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if IsHealthCheck(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ok, wait := l.Allow(matchedRouteName(router, r), key(r), time.Now())
+			if ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			SetRetryAfter(w.Header(), wait)
+			Reject(w, r, http.StatusTooManyRequests, "over the rate limit")
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestRateLimiterRefill(t *testing.T) {
+	l := NewRateLimiter(RateLimit{Rate: 2, Burst: 2}, nil)
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("", "ip:192.0.2.1", now); !ok {
+			t.Fatalf("request %d of the burst refused", i+1)
+		}
+	}
+	ok, wait := l.Allow("", "ip:192.0.2.1", now)
+	if ok || wait != 500*time.Millisecond {
+		t.Errorf("got %v waiting %s past the burst, want a refusal waiting 500ms", ok, wait)
+	}
+	if ok, _ := l.Allow("", "ip:192.0.2.1", now.Add(250*time.Millisecond)); ok {
+		t.Error("allowed with half a token refilled")
+	}
+	if ok, _ := l.Allow("", "ip:192.0.2.1", now.Add(750*time.Millisecond)); !ok {
+		t.Error("refused once a token refilled")
+	}
+}
+
+func TestRateLimiterRoutes(t *testing.T) {
+	l := NewRateLimiter(RateLimit{Rate: 1, Burst: 1}, RateLimits{
+		"search":  {Rate: 1, Burst: 2},
+		"healthz": {},
+	})
+	now := time.Now()
+
+	tests := []struct {
+		route   string
+		allowed int
+	}{
+		{"index", 1},
+		{"search", 2},
+		{"healthz", 10},
+	}
+	for _, tt := range tests {
+		allowed := 0
+		for i := 0; i < 10; i++ {
+			if ok, _ := l.Allow(tt.route, "ip:192.0.2.1", now); ok {
+				allowed++
+			}
+		}
+		if allowed != tt.allowed {
+			t.Errorf("route %s allowed %d requests at once, want %d", tt.route, allowed, tt.allowed)
+		}
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	captureLog(t, accessLog)
+	captureLog(t, errorLog)
+
+	router := mux.NewRouter()
+	Handle(router, "search", "/search", []string{"GET"}, func(w http.ResponseWriter, r *http.Request) {})
+	l := NewRateLimiter(RateLimit{Rate: 1, Burst: 1}, RateLimits{"search": {Rate: 1, Burst: 2}})
+	h := RateLimitMiddleware(l, RateLimitByIP, router)(router)
+
+	serve := func(path, client string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.RemoteAddr = client + ":1234"
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := serve("/search", "192.0.2.1"); w.Code != http.StatusOK {
+			t.Fatalf("request %d within the route's burst got %d", i+1, w.Code)
+		}
+	}
+	w := serve("/search", "192.0.2.1")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("got %d with Retry-After %q over the limit, want a 429 with one", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// Clients and routes without an override have buckets of their own
+	if w := serve("/search", "192.0.2.2"); w.Code != http.StatusOK {
+		t.Errorf("another client got %d, want it unaffected", w.Code)
+	}
+	if w := serve("/unknown", "192.0.2.1"); w.Code != http.StatusNotFound {
+		t.Errorf("an unknown path got %d within the global limit, want a 404", w.Code)
+	}
+	if w := serve("/unknown", "192.0.2.1"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("an unknown path got %d over the global limit, want a 429", w.Code)
+	}
+}
+
+func TestRateLimitByUser(t *testing.T) {
+	captureLog(t, accessLog)
+
+	router := mux.NewRouter()
+	Handle(router, "search", "/search", []string{"GET"}, func(w http.ResponseWriter, r *http.Request) {})
+	l := NewRateLimiter(RateLimit{Rate: 1, Burst: 1}, nil)
+	limited := RateLimitMiddleware(l, RateLimitByUser, router)(router)
+
+	serve := func(user string) int {
+		r := httptest.NewRequest(http.MethodGet, "/search", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		if user != "" {
+			r = WithUser(r, user)
+		}
+		w := httptest.NewRecorder()
+		limited.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// Behind one IP, each user and the anonymous requests get a bucket
+	for _, user := range []string{"alice", "bob", ""} {
+		if code := serve(user); code != http.StatusOK {
+			t.Errorf("%q's first request got %d, want 200", user, code)
+		}
+		if code := serve(user); code != http.StatusTooManyRequests {
+			t.Errorf("%q's second request got %d, want 429", user, code)
+		}
+	}
+}
+
+func TestRateLimitByUserValidated(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RateLimitBy = "user"
+	if err := cfg.validate(); err == nil {
+		t.Error("RATE_LIMIT_BY=user accepted with nothing setting the user")
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ReadOnly is a finer grained maintenance mode, refusing only the requests
+// that could change anything
+type ReadOnly struct {
+	on atomic.Bool
+}
+
+var readOnly *ReadOnly = &ReadOnly{}
+
+func (ro *ReadOnly) Enabled() bool {
+	return ro.on.Load()
+}
+
+// Set switches read-only mode, logging only actual changes along with what
+// asked for them
+func (ro *ReadOnly) Set(on bool, by string) {
+	if ro.on.Swap(on) == on {
+		return
+	}
+	if on {
+		log.Println("| Entering read-only mode, by " + by)
+	} else {
+		log.Println("| Leaving read-only mode, by " + by)
+	}
+}
+
+func (ro *ReadOnly) Toggle(by string) {
+	ro.Set(!ro.Enabled(), by)
+}
+
+// ReadOnlyMiddleware answers POST, PUT, PATCH and DELETE requests with a 503
+// while read-only mode is on, reads still being served. Health checks and
+// the admin endpoints, which turn it off, are exempt.
+func ReadOnlyMiddleware(ro *ReadOnly, retryAfter time.Duration, message string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !ro.Enabled() || isSafeMethod(r.Method) || IsHealthCheck(r) || IsAdminPath(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			LogRejection(r, r.URL.Path, http.StatusServiceUnavailable, "read-only mode")
+			SetRetryAfter(w.Header(), retryAfter)
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(message + "\n"))
+		})
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !unix
+
+package main
+
+// WatchReadOnlySignal is a no-op where there's no SIGUSR1, the admin
+// endpoint still toggles read-only mode
+func WatchReadOnlySignal(ro *ReadOnly) {}
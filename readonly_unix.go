@@ -0,0 +1,21 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// WatchReadOnlySignal toggles read-only mode on every SIGUSR1
+func WatchReadOnlySignal(ro *ReadOnly) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR1)
+
+	go func() {
+		for range c {
+			ro.Toggle("SIGUSR1")
+		}
+	}()
+}
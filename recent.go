@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// RecentRequests keeps the last access log entries in a fixed size ring. A
+// nil *RecentRequests keeps nothing.
+type RecentRequests struct {
+	mu      sync.Mutex
+	entries []RequestLogger
+	next    int
+	full    bool
+}
+
+var recentRequests *RecentRequests
+
+func NewRecentRequests(size int) *RecentRequests {
+	if size <= 0 {
+		return nil
+	}
+	return &RecentRequests{entries: make([]RequestLogger, size)}
+}
+
+func (rr *RecentRequests) Add(rl *RequestLogger) {
+	if rr == nil {
+		return
+	}
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	rr.entries[rr.next] = *rl
+	rr.next = (rr.next + 1) % len(rr.entries)
+	if rr.next == 0 {
+		rr.full = true
+	}
+}
+
+// List copies the entries out, oldest first
+func (rr *RecentRequests) List() []RequestLogger {
+	if rr == nil {
+		return nil
+	}
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	if !rr.full {
+		return append([]RequestLogger{}, rr.entries[:rr.next]...)
+	}
+	return append(
+		append([]RequestLogger{}, rr.entries[rr.next:]...),
+		rr.entries[:rr.next]...,
+	)
+}
+
+// RecentRequestsHandler serves the recent entries as a JSON array, each one
+// as the JSON log format has it
+func RecentRequestsHandler(rr *RecentRequests) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entries := []json.RawMessage{}
+		for _, rl := range rr.List() {
+			entries = append(entries, json.RawMessage(rl.JSONString()))
+		}
+		writeJSON(w, http.StatusOK, entries)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+)
+
+// Reject answers a request that is refused before reaching a handler and logs
+// it in the access log layout, followed by the reason.
+func Reject(w http.ResponseWriter, r *http.Request, status int, reason string) {
+	LogRejection(r, r.URL.Path, status, reason)
+	http.Error(w, http.StatusText(status), status)
+}
+
+func rejectPath(w http.ResponseWriter, r *http.Request, path string, status int, reason string) {
+	LogRejection(r, path, status, reason)
+	http.Error(w, http.StatusText(status), status)
+}
+
+// LogRejection logs a refused request, for middlewares answering it with
+// their own body
+func LogRejection(r *http.Request, path string, status int, reason string) {
+	rl :=
+		NewRequestLoggerBuilder().
+			SetRequestID(RequestIDFromContext(r)).
+			SetMethod(r.Method).
+			SetStatus(status).
+			SetPath(path).
+			SetStart(RequestStart(r)).
+			SetClientIP(ClientIP(r)).
+			SetUser(basicAuthUser(r)).
+			SetRequestLine(r.RequestURI, r.Proto).
+			SetMessage(reason)
+
+	statusCounters.Observe(status)
+	accessLog.Print(StatusLevel(status), rl)
+}
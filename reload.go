@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// reloadable are the config keys applied while serving, changing any other
+// one only takes effect on restart
+var reloadable = map[string]bool{
+	"access_log_level":      true,
+	"error_log_level":       true,
+	"log_format":            true,
+	"error_log_format":      true,
+	"slow_render_threshold": true,
+	"render_buffer_size":    true,
+	"log_time_precision":    true,
+}
+
+var reloadMu sync.Mutex
+
+// currentConfig is the config in effect, kept up to date by Reload
+var currentConfig atomic.Pointer[Config]
+
+// Reload re-reads the config from the same args it was first loaded with and
+// applies the reloadable settings that changed. The new config is validated
+// as a whole first, so a bad file changes nothing. It returns the config now
+// in effect.
+func Reload(current *Config, args []string) *Config {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	next, err := LoadConfig(args)
+	if err != nil {
+		log.Println("| Reload failed, keeping the current config: " + err.Error())
+		return current
+	}
+
+	applied := *current
+	changed, restart := applied.merge(next)
+
+	accessLog.SetLevel(applied.AccessLogLevel)
+	accessLog.SetFormat(applied.LogFormat)
+	errorLog.SetLevel(applied.ErrorLogLevel)
+	errorLog.SetFormat(applied.ErrorLogFormat)
+	SetSlowRenderThreshold(time.Duration(applied.SlowRenderThreshold))
+	SetRenderBufferSize(applied.RenderBufferSize)
+
+	// Validated along with the rest of the config
+	precision, _ := ParseTimePrecision(applied.LogTimePrecision)
+	accessLog.SetPrecision(precision)
+	errorLog.SetPrecision(precision)
+
+	if len(changed) == 0 {
+		log.Println("| Reloaded config, nothing to apply")
+	} else {
+		log.Println("| Reloaded config: " + strings.Join(changed, ", "))
+	}
+	if len(restart) > 0 {
+		log.Println("| Restart to apply: " + strings.Join(restart, ", "))
+	}
+
+	currentConfig.Store(&applied)
+
+	return &applied
+}
+
+// merge copies the reloadable fields of next that differ into cfg. It
+// returns them as "key: old -> new", and the keys of the other fields that
+// differ, without their values as they may be secrets.
+func (cfg *Config) merge(next *Config) (changed, restart []string) {
+	cv := reflect.ValueOf(cfg).Elem()
+	nv := reflect.ValueOf(next).Elem()
+
+	for i := 0; i < cv.NumField(); i++ {
+		key, _, _ := strings.Cut(cv.Type().Field(i).Tag.Get("json"), ",")
+		old, value := cv.Field(i), nv.Field(i)
+
+		if reflect.DeepEqual(old.Interface(), value.Interface()) {
+			continue
+		}
+		if !reloadable[key] {
+			restart = append(restart, key)
+			continue
+		}
+
+		changed = append(changed, fmt.Sprintf(
+			"%s: %v -> %v", key, old.Addr().Interface(), value.Addr().Interface(),
+		))
+		old.Set(value)
+	}
+	return changed, restart
+}
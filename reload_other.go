@@ -0,0 +1,6 @@
+//go:build !unix
+
+package main
+
+// WatchReloadSignal is a no-op where there's no SIGHUP
+func WatchReloadSignal(cfg *Config, args []string) {}
@@ -0,0 +1,21 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// WatchReloadSignal reloads the config from args on every SIGHUP
+func WatchReloadSignal(cfg *Config, args []string) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+
+	go func() {
+		for range c {
+			cfg = Reload(cfg, args)
+		}
+	}()
+}
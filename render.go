@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// slowRenderThreshold and renderBufferSize are read on every render and
+// swapped on config reload
+var (
+	slowRenderThreshold atomic.Int64
+	renderBufferSize    atomic.Int64
+)
+
+func init() {
+	SetSlowRenderThreshold(100 * time.Millisecond)
+	SetRenderBufferSize(1 << 20)
+}
+
+func SetSlowRenderThreshold(d time.Duration) {
+	slowRenderThreshold.Store(int64(d))
+}
+
+func SlowRenderThreshold() time.Duration {
+	return time.Duration(slowRenderThreshold.Load())
+}
+
+func SetRenderBufferSize(size int) {
+	renderBufferSize.Store(int64(size))
+}
+
+// renderBuffer holds a render's output until it's known to be whole, so a
+// failing one can still answer a clean 500. Past max bytes it gives up on
+// that, sending what it holds and streaming the rest.
+type renderBuffer struct {
+	w         http.ResponseWriter
+	max       int64
+	buf       bytes.Buffer
+	sent      int64
+	streaming bool
+}
+
+func (rb *renderBuffer) Write(b []byte) (int, error) {
+	if !rb.streaming && int64(rb.buf.Len()+len(b)) > rb.max {
+		rb.streaming = true
+		if err := rb.flush(); err != nil {
+			return 0, err
+		}
+	}
+	if !rb.streaming {
+		return rb.buf.Write(b)
+	}
+	n, err := rb.w.Write(b)
+	rb.sent += int64(n)
+	return n, err
+}
+
+// flush sends whatever is buffered
+func (rb *renderBuffer) flush() error {
+	n, err := rb.w.Write(rb.buf.Bytes())
+	rb.sent += int64(n)
+	rb.buf.Reset()
+	return err
+}
+
+// TemplateFuncs are the functions templates can call about the request
+// they render. Templates are parsed with TemplateFuncs(nil), whose functions
+// return zero values, and Render binds them to each request.
+func TemplateFuncs(r *http.Request) template.FuncMap {
+	return template.FuncMap{
+		"csrfToken": func() string {
+			if r == nil {
+				return ""
+			}
+			return CSRFToken(r)
+		},
+	}
+}
+
+// Render executes tmpl into a buffer of up to RENDER_BUFFER_SIZE bytes and
+// only then writes it, so a render failing halfway answers a 500 instead of
+// a cut short 200. Larger pages are streamed once over it, a failure then
+// only being logged.
+func Render(w http.ResponseWriter, r *http.Request, tmpl *template.Template, data interface{}) {
+	start := time.Now()
+	rb := &renderBuffer{w: w, max: renderBufferSize.Load()}
+
+	// Clones share the parsed tree, only the function bindings are copied
+	clone, err := tmpl.Clone()
+	if err == nil {
+		err = clone.Funcs(TemplateFuncs(r)).Execute(rb, data)
+	}
+	if err == nil && !rb.streaming {
+		err = rb.flush()
+	}
+	since := time.Since(start)
+
+	switch {
+	case err != nil && rb.streaming:
+		LoggerFromContext(r).Printf(
+			"%sRENDER%s %s %s%s, after %d bytes were sent%s",
+			colors.Red, colors.Reset, tmpl.Name(),
+			colors.Red, err.Error(), rb.sent, colors.Reset,
+		)
+	case err != nil:
+		LoggerFromContext(r).Printf(
+			"%sRENDER%s %s %s%s%s",
+			colors.Red, colors.Reset, tmpl.Name(),
+			colors.Red, err.Error(), colors.Reset,
+		)
+		if rb.sent == 0 {
+			writeError(w, r, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		}
+	case since > SlowRenderThreshold():
+		LoggerFromContext(r).Printf(
+			"%sRENDER%s %s %sslow render: %s%s",
+			colors.Yellow, colors.Reset, tmpl.Name(),
+			colors.Yellow, since, colors.Reset,
+		)
+	}
+}
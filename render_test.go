@@ -0,0 +1,39 @@
+package main
+
+import (
+	"html/template"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	tmpl := template.Must(template.New("page").Funcs(TemplateFuncs(nil)).Parse("<p>{{.}}</p>"))
+
+	w := httptest.NewRecorder()
+	Render(w, httptest.NewRequest(http.MethodGet, "/", nil), tmpl, "hello")
+	if w.Code != http.StatusOK || w.Body.String() != "<p>hello</p>" {
+		t.Errorf("got %d %q, want the page", w.Code, w.Body.String())
+	}
+}
+
+func TestRenderCloneFails(t *testing.T) {
+	std := captureStdLog(t)
+
+	// html/template refuses to clone a template once it was executed
+	tmpl := template.Must(template.New("executed").Funcs(TemplateFuncs(nil)).Parse("{{.}}"))
+	if err := tmpl.Execute(io.Discard, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	Render(w, httptest.NewRequest(http.MethodGet, "/", nil), tmpl, "hello")
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("got %d, want 500", w.Code)
+	}
+	if !strings.Contains(std.String(), "executed") {
+		t.Errorf("got log %q, want the template's name", std.String())
+	}
+}
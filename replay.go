@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// ReplayPath serves ReplayHandler with DEBUG
+const ReplayPath string = "/debug/replay"
+
+// ReplayRequest is a captured request to replay, like one from the curl log
+// or a bug report. Path may carry a query string.
+type ReplayRequest struct {
+	Method  string      `json:"method"`
+	Path    string      `json:"path"`
+	Headers http.Header `json:"headers"`
+	Body    string      `json:"body"`
+}
+
+// ReplayedResponse is what the router answered, the body base64 encoded
+// when it isn't valid UTF-8
+type ReplayedResponse struct {
+	RequestID    string      `json:"request_id"`
+	Status       int         `json:"status"`
+	Headers      http.Header `json:"headers"`
+	Body         string      `json:"body"`
+	BodyEncoding string      `json:"body_encoding,omitempty"`
+}
+
+// replayable refuses the admin and debug endpoints, this one included,
+// wherever dot segments would lead
+func replayable(p string) bool {
+	p = path.Clean("/" + p)
+	if p == AdminPrefix || strings.HasPrefix(p, AdminPrefix+"/") {
+		return false
+	}
+	return p != "/debug" && !strings.HasPrefix(p, "/debug/")
+}
+
+// ReplayHandler replays the ReplayRequest in the body through router, in
+// memory, and answers the captured response as JSON. The replay gets its
+// own "replay-" prefixed request ID, so its access line stands out, and the
+// caller's connection, host and cancellation, but none of its context values.
+func ReplayHandler(router http.Handler) http.HandlerFunc {
+	// The pre-routing chain doesn't run again, only the request ID is set
+	// for the in-route middlewares and handlers to log with
+	replay := RequestIDMiddleware(router)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rr ReplayRequest
+		if err := json.NewDecoder(r.Body).Decode(&rr); err != nil {
+			writeError(w, r, http.StatusBadRequest, "replay: "+err.Error())
+			return
+		}
+		if rr.Method == "" {
+			rr.Method = http.MethodGet
+		}
+		target, err := url.ParseRequestURI(rr.Path)
+		if err != nil || !strings.HasPrefix(target.Path, "/") {
+			writeError(w, r, http.StatusBadRequest, "replay: path must be absolute, like /items?page=2")
+			return
+		}
+		if !replayable(target.Path) {
+			writeError(w, r, http.StatusForbidden, "replay: admin and debug endpoints can't be replayed")
+			return
+		}
+
+		// Cancelled with the caller's, without its values, like a verified
+		// signature or a user, which would spare the replay their checks
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		stop := context.AfterFunc(r.Context(), cancel)
+		defer stop()
+
+		req, err := http.NewRequestWithContext(ctx, rr.Method, target.RequestURI(), strings.NewReader(rr.Body))
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "replay: "+err.Error())
+			return
+		}
+		req.RequestURI = target.RequestURI()
+		req.Host = r.Host
+		req.RemoteAddr = r.RemoteAddr
+		req.TLS = r.TLS
+		for name, values := range rr.Headers {
+			req.Header[http.CanonicalHeaderKey(name)] = values
+		}
+		if host := req.Header.Get("Host"); host != "" {
+			req.Host = host
+			req.Header.Del("Host")
+		}
+		id := "replay-" + NewRequestID()
+		req.Header.Set(RequestIDHeader, id)
+
+		LoggerFromContext(r).Printf("replaying %s %s as %s", sanitize(req.Method), sanitize(req.RequestURI), id)
+
+		recorder := httptest.NewRecorder()
+		replay.ServeHTTP(recorder, req)
+
+		res := ReplayedResponse{
+			RequestID: id,
+			Status:    recorder.Code,
+			Headers:   recorder.Header(),
+			Body:      recorder.Body.String(),
+		}
+		if !utf8.Valid(recorder.Body.Bytes()) {
+			res.Body = base64.StdEncoding.EncodeToString(recorder.Body.Bytes())
+			res.BodyEncoding = "base64"
+		}
+		writeJSON(w, http.StatusOK, res)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// replay posts rr to ReplayHandler(router) on behalf of an outer request
+// with ctx, returning the replayed response
+func replay(t *testing.T, router http.Handler, ctx context.Context, rr ReplayRequest) (int, ReplayedResponse) {
+	t.Helper()
+	body, _ := json.Marshal(rr)
+	r := httptest.NewRequest(http.MethodPost, ReplayPath, strings.NewReader(string(body))).WithContext(ctx)
+	w := httptest.NewRecorder()
+	ReplayHandler(router).ServeHTTP(w, r)
+
+	var res ReplayedResponse
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatalf("replayed response %q: %v", w.Body.String(), err)
+		}
+	}
+	return w.Code, res
+}
+
+func TestReplayChecksAgain(t *testing.T) {
+	captureStdLog(t)
+	captureLog(t, errorLog)
+
+	csrf, err := NewCSRF("secret", "csrf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	router.Use(CSRFMiddleware(csrf))
+	Handle(router, "items", "/items", []string{"POST"}, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+	Handle(router, "whoami", "/whoami", []string{"GET"}, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "user=%q signed=%v", UserFromContext(r), SignedFromContext(r))
+	})
+
+	// As if the replay request itself was signed and authenticated
+	outer := httptest.NewRequest(http.MethodPost, ReplayPath, nil)
+	outer = WithUser(outer, "admin")
+	ctx := context.WithValue(outer.Context(), signedKey, true)
+
+	code, res := replay(t, router, ctx, ReplayRequest{Method: http.MethodPost, Path: "/items"})
+	if code != http.StatusOK || res.Status != http.StatusForbidden {
+		t.Errorf("got %d replaying %d, want the CSRF check's 403", code, res.Status)
+	}
+	code, res = replay(t, router, ctx, ReplayRequest{Path: "/whoami"})
+	if code != http.StatusOK || res.Body != `user="" signed=false` {
+		t.Errorf("got %d replaying %q, want neither the user nor the signature", code, res.Body)
+	}
+}
+
+func TestReplayCancelled(t *testing.T) {
+	captureStdLog(t)
+	captureLog(t, errorLog)
+
+	router := mux.NewRouter()
+	Handle(router, "wait", "/wait", []string{"GET"}, func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		w.WriteHeader(http.StatusGatewayTimeout)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, res := replay(t, router, ctx, ReplayRequest{Path: "/wait"}); res.Status != http.StatusGatewayTimeout {
+		t.Errorf("got %d, want the replay cancelled with the caller", res.Status)
+	}
+}
+
+func TestReplayRefusesAdminAndDebug(t *testing.T) {
+	captureStdLog(t)
+	captureLog(t, errorLog)
+	router := mux.NewRouter()
+
+	for _, path := range []string{
+		AdminPrefix, AdminPrefix + "/maintenance", "/debug", ReplayPath, "/debug/vars",
+		"/items/../admin/maintenance", "/x/../../debug/config", "//debug/vars",
+	} {
+		if code, _ := replay(t, router, context.Background(), ReplayRequest{Path: path}); code != http.StatusForbidden {
+			t.Errorf("%s: got %d, want 403", path, code)
+		}
+	}
+	for _, path := range []string{"/debugger", "/items?next=/admin"} {
+		code, res := replay(t, router, context.Background(), ReplayRequest{Path: path})
+		if code != http.StatusOK || res.Status != http.StatusNotFound {
+			t.Errorf("%s: got %d replaying %d, want the router's 404", path, code, res.Status)
+		}
+	}
+	if code, _ := replay(t, router, context.Background(), ReplayRequest{Path: "items"}); code != http.StatusBadRequest {
+		t.Errorf("relative path: got %d, want 400", code)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type seenID struct {
+	id string
+	at time.Time
+}
+
+// RequestIDCache remembers request IDs for ttl, at most size of them, the
+// oldest being forgotten first either way
+type RequestIDCache struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	size  int
+	seen  map[string]time.Time
+	order []seenID
+}
+
+func NewRequestIDCache(ttl time.Duration, size int) *RequestIDCache {
+	return &RequestIDCache{ttl: ttl, size: size, seen: map[string]time.Time{}}
+}
+
+// Seen reports whether id was already seen within the ttl, remembering it
+// otherwise
+func (c *RequestIDCache) Seen(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for len(c.order) > 0 && now.Sub(c.order[0].at) > c.ttl {
+		c.evictOldest()
+	}
+
+	if _, ok := c.seen[id]; ok {
+		return true
+	}
+	if len(c.order) > 0 && len(c.order) >= c.size {
+		c.evictOldest()
+	}
+	c.seen[id] = now
+	c.order = append(c.order, seenID{id, now})
+	return false
+}
+
+func (c *RequestIDCache) evictOldest() {
+	delete(c.seen, c.order[0].id)
+	c.order = c.order[1:]
+}
+
+// UniqueRequestIDMiddleware answers a 409 to requests reusing an incoming
+// X-Request-ID seen within the cache's ttl, guarding against replays. It
+// goes after RequestIDMiddleware, generated IDs aren't checked. A nil cache
+// disables it.
+func UniqueRequestIDMiddleware(c *RequestIDCache) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		if c == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := RequestIDFromContext(r)
+			if r.Header.Get(RequestIDHeader) == id && c.Seen(id) {
+				Reject(w, r, http.StatusConflict, "duplicate request ID "+strconv.Quote(id))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRequestIDCache(t *testing.T) {
+	c := NewRequestIDCache(time.Minute, 2)
+	for _, id := range []string{"a", "b"} {
+		if c.Seen(id) {
+			t.Fatalf("%s seen before it was", id)
+		}
+	}
+	if !c.Seen("a") {
+		t.Error("a not seen again")
+	}
+	// Over the size, a is forgotten first
+	c.Seen("c")
+	if c.Seen("a") {
+		t.Error("a still seen past the cache size")
+	}
+
+	c = NewRequestIDCache(time.Millisecond, 2)
+	c.Seen("a")
+	time.Sleep(5 * time.Millisecond)
+	if c.Seen("a") {
+		t.Error("a still seen past the ttl")
+	}
+}
+
+func TestRequestIDCacheSizeValidated(t *testing.T) {
+	// An empty cache with nothing to evict doesn't panic
+	if NewRequestIDCache(time.Minute, 0).Seen("a") {
+		t.Error("a seen in an empty cache")
+	}
+
+	cfg := DefaultConfig()
+	cfg.RequestIDTTL = Duration(time.Minute)
+	for _, size := range []int{0, -1} {
+		cfg.RequestIDCacheSize = size
+		if err := cfg.validate(); err == nil {
+			t.Errorf("cache size %d accepted with a ttl", size)
+		}
+	}
+	cfg.RequestIDCacheSize = 1
+	if err := cfg.validate(); err != nil {
+		t.Errorf("cache size 1 refused: %v", err)
+	}
+	cfg.RequestIDTTL, cfg.RequestIDCacheSize = 0, 0
+	if err := cfg.validate(); err != nil {
+		t.Errorf("cache size 0 refused without a ttl: %v", err)
+	}
+}
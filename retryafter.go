@@ -0,0 +1,27 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// retryAfterJitter spreads the Retry-After of 503s and 429s over base to base+jitter,
+// so clients turned away together don't all come back together
+var retryAfterJitter time.Duration
+
+// SetRetryAfter sets Retry-After to base plus a random share of the jitter,
+// in whole delay-seconds as RFC 9110 has it, rounded up and at least 1
+func SetRetryAfter(h http.Header, base time.Duration) {
+	delay := base
+	if retryAfterJitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(retryAfterJitter) + 1))
+	}
+	seconds := int(math.Ceil(delay.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	h.Set("Retry-After", strconv.Itoa(seconds))
+}
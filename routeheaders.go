@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+
+	"github.com/gorilla/mux"
+)
+
+// headerDefaultsWriter adds the default headers the handler didn't set
+// itself when the response starts. The values already there when the route
+// was entered, like global ones, give way to the defaults unless the handler
+// changed them.
+type headerDefaultsWriter struct {
+	http.ResponseWriter
+	defaults  map[string]string
+	inherited map[string][]string
+	applied   bool
+}
+
+func newHeaderDefaultsWriter(w http.ResponseWriter, defaults map[string]string) *headerDefaultsWriter {
+	inherited := map[string][]string{}
+	for key := range defaults {
+		key = http.CanonicalHeaderKey(key)
+		if values, ok := w.Header()[key]; ok {
+			inherited[key] = slices.Clone(values)
+		}
+	}
+	return &headerDefaultsWriter{ResponseWriter: w, defaults: defaults, inherited: inherited}
+}
+
+func (hw *headerDefaultsWriter) apply() {
+	if hw.applied {
+		return
+	}
+	hw.applied = true
+	h := hw.Header()
+	for key, value := range hw.defaults {
+		key = http.CanonicalHeaderKey(key)
+		values, set := h[key]
+		inherited, ok := hw.inherited[key]
+		if !set || ok && slices.Equal(values, inherited) {
+			h.Set(key, value)
+		}
+	}
+}
+
+func (hw *headerDefaultsWriter) WriteHeader(status int) {
+	if status >= 200 {
+		hw.apply()
+	}
+	hw.ResponseWriter.WriteHeader(status)
+}
+
+func (hw *headerDefaultsWriter) Write(b []byte) (int, error) {
+	hw.apply()
+	return hw.ResponseWriter.Write(b)
+}
+
+func (hw *headerDefaultsWriter) Flush() {
+	hw.apply()
+	if f, ok := hw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (hw *headerDefaultsWriter) Unwrap() http.ResponseWriter {
+	return hw.ResponseWriter
+}
+
+// DefaultHeadersMiddleware sets headers on responses the handler gave no
+// value of its own, handler set ones always winning and the defaults winning
+// over values set before, like global headers. It can wrap a single route's
+// handler, like ValidateVarsMiddleware.
+func DefaultHeadersMiddleware(headers map[string]string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		if len(headers) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(newHeaderDefaultsWriter(w, headers), r)
+		})
+	}
+}
+
+// RouteHeaders are default response headers by route name. From env and
+// flags they read as the same JSON object as in the config file, like
+// {"index": {"Cache-Control": "no-cache"}}.
+type RouteHeaders map[string]map[string]string
+
+func (rh *RouteHeaders) Set(v string) error {
+	headers := RouteHeaders{}
+	if err := json.Unmarshal([]byte(v), &headers); err != nil {
+		return err
+	}
+	*rh = headers
+	return nil
+}
+
+func (rh *RouteHeaders) String() string {
+	b, _ := json.Marshal(*rh)
+	return string(b)
+}
+
+// RouteHeadersMiddleware applies the default headers of the matched route
+func RouteHeadersMiddleware(rh RouteHeaders) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		if len(rh) == 0 {
+			return next
+		}
+		routes := map[string]http.Handler{}
+		for route, headers := range rh {
+			routes[route] = DefaultHeadersMiddleware(headers)(next)
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if h, ok := routes[RouteName(r)]; ok {
+				h.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
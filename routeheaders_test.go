@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestRouteHeadersPrecedence(t *testing.T) {
+	router := mux.NewRouter()
+	router.Use(RouteHeadersMiddleware(RouteHeaders{
+		"static": {"Cache-Control": "max-age=3600", "X-Route": "static"},
+		"api":    {"Cache-Control": "no-store", "Content-Type": "application/json"},
+	}))
+	Handle(router, "static", "/static", []string{"GET"}, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("static"))
+	})
+	Handle(router, "api", "/api", []string{"GET"}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "private")
+		w.Header().Del("X-Global")
+		w.Write([]byte("{}"))
+	})
+	Handle(router, "plain", "/plain", []string{"GET"}, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("plain"))
+	})
+
+	// Global headers, set before routing like ServedBy's
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Global", "yes")
+		router.ServeHTTP(w, r)
+	})
+
+	tests := []struct {
+		path   string
+		header string
+		want   string
+	}{
+		// Route default over the global header
+		{"/static", "Cache-Control", "max-age=3600"},
+		{"/static", "X-Route", "static"},
+		{"/static", "X-Global", "yes"},
+		// Handler set over both
+		{"/api", "Cache-Control", "private"},
+		{"/api", "Content-Type", "application/json"},
+		{"/api", "X-Global", ""},
+		// Global header when the route has no defaults
+		{"/plain", "Cache-Control", "no-cache"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if got := w.Header().Get(tt.header); got != tt.want {
+			t.Errorf("%s %s: got %q, want %q", tt.path, tt.header, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+func OptionsHandler(methods ...string) http.HandlerFunc {
+	allow := strings.Join(methods, ", ")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", allow)
+		if r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", allow)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// RouteTemplate is the matched route's path template, like /items/{id}, or
+// the raw path when no route matched
+func RouteTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return r.URL.Path
+}
+
+func Handle(
+	router *mux.Router,
+	name, path string,
+	methods []string,
+	h http.HandlerFunc,
+) *mux.Route {
+	return router.
+		Name(name).
+		Path(path).
+		HandlerFunc(h).
+		Methods(methods...)
+}
+
+// HandleWithOptions registers h like Handle and adds a name_options route on
+// the same path answering OPTIONS with the allowed methods.
+func HandleWithOptions(
+	router *mux.Router,
+	name, path string,
+	methods []string,
+	h http.HandlerFunc,
+) *mux.Route {
+	allowed := append(append([]string{}, methods...), http.MethodOptions)
+
+	Handle(
+		router,
+		name+"_options",
+		path,
+		[]string{http.MethodOptions},
+		OptionsHandler(allowed...),
+	)
+
+	return Handle(router, name, path, methods, h)
+}
+
+// RouterMethods lists every method some route of router answers, sorted
+func RouterMethods(router *mux.Router) []string {
+	seen := map[string]bool{}
+	router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		methods, _ := route.GetMethods()
+		for _, method := range methods {
+			seen[method] = true
+		}
+		return nil
+	})
+	methods := []string{}
+	for method := range seen {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// AsteriskOptionsMiddleware answers OPTIONS *, which asks about the server
+// as a whole rather than a resource, with a 200 allowing methods. The
+// router would redirect it to /* instead. It's logged like routed
+// requests, which takes the server's DisableGeneralOptionsHandler, or it
+// never gets here.
+func AsteriskOptionsMiddleware(methods ...string) mux.MiddlewareFunc {
+	allow := strings.Join(methods, ", ")
+	options := LoggerMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", allow)
+		w.Header().Set("Content-Length", "0")
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodOptions && r.RequestURI == "*" {
+				options.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// builtinRoute reports whether name is registered by the server itself
+// rather than being part of the application
+func builtinRoute(name string) bool {
+	switch name {
+	case "healthz", "readyz", "metrics", "debug_vars", "debug_requests", "debug_config",
+		"debug_error_rates", "debug_replay", "favicon":
+		return true
+	}
+	return strings.HasPrefix(name, "admin_") || strings.HasSuffix(name, "_options")
+}
+
+// CheckRoutes walks the router and errors when it holds fewer than min
+// application routes, catching deployments that skipped registering them
+// and would only ever answer 404s.
+func CheckRoutes(router *mux.Router, min int) error {
+	count := 0
+	router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		if route.GetHandler() != nil && !builtinRoute(route.GetName()) {
+			count++
+		}
+		return nil
+	})
+	if count < min {
+		return fmt.Errorf("routes: only %d application routes registered, expected at least %d", count, min)
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestCheckRoutesSkipsBuiltins(t *testing.T) {
+	router := mux.NewRouter()
+	noop := func(w http.ResponseWriter, r *http.Request) {}
+	for _, name := range []string{
+		"healthz", "readyz", "metrics", "favicon", "admin_maintenance", "items_options",
+		"debug_vars", "debug_requests", "debug_config", "debug_error_rates", "debug_replay",
+	} {
+		Handle(router, name, "/"+name, []string{"GET"}, noop)
+	}
+	if err := CheckRoutes(router, 1); err == nil {
+		t.Error("built-in routes counted as application ones")
+	}
+
+	Handle(router, "items", "/items", []string{"GET"}, noop)
+	if err := CheckRoutes(router, 1); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// RouteSwitch holds the names of the routes disabled at runtime
+type RouteSwitch struct {
+	mu       sync.RWMutex
+	disabled map[string]bool
+}
+
+var disabledRoutes *RouteSwitch = &RouteSwitch{disabled: map[string]bool{}}
+
+func (s *RouteSwitch) Disabled(name string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.disabled[name]
+}
+
+// Set disables or enables the route name, logging only actual changes along
+// with what asked for them
+func (s *RouteSwitch) Set(name string, disabled bool, by string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.disabled[name] == disabled {
+		return
+	}
+	if disabled {
+		s.disabled[name] = true
+		log.Println("| Disabling route " + name + ", by " + by)
+	} else {
+		delete(s.disabled, name)
+		log.Println("| Enabling route " + name + ", by " + by)
+	}
+}
+
+func (s *RouteSwitch) List() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := []string{}
+	for name := range s.disabled {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RouteSwitchMiddleware answers the disabled routes with a 503, the others
+// keep serving
+func RouteSwitchMiddleware(s *RouteSwitch) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if s.Disabled(RouteName(r)) {
+				SetRetryAfter(w.Header(), 0)
+				http.Error(
+					w,
+					http.StatusText(http.StatusServiceUnavailable),
+					http.StatusServiceUnavailable,
+				)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type routeInfo struct {
+	Name     string   `json:"name"`
+	Path     string   `json:"path"`
+	Methods  []string `json:"methods"`
+	Disabled bool     `json:"disabled"`
+}
+
+// RouteInfos lists the named routes of router in registration order
+func RouteInfos(router *mux.Router, s *RouteSwitch) []routeInfo {
+	routes := []routeInfo{}
+	router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		if route.GetName() == "" {
+			return nil
+		}
+		path, _ := route.GetPathTemplate()
+		methods, _ := route.GetMethods()
+		routes = append(routes, routeInfo{
+			Name:     route.GetName(),
+			Path:     path,
+			Methods:  methods,
+			Disabled: s.Disabled(route.GetName()),
+		})
+		return nil
+	})
+	return routes
+}
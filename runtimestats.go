@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"time"
+)
+
+type RuntimeStats struct {
+	Goroutines int    `json:"goroutines"`
+	HeapAlloc  uint64 `json:"heap_alloc"`
+	HeapInuse  uint64 `json:"heap_inuse"`
+	Sys        uint64 `json:"sys"`
+	NumGC      uint32 `json:"num_gc"`
+}
+
+// ReadRuntimeStats briefly stops the world to read the memory stats
+func ReadRuntimeStats() RuntimeStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	return RuntimeStats{
+		Goroutines: runtime.NumGoroutine(),
+		HeapAlloc:  m.HeapAlloc,
+		HeapInuse:  m.HeapInuse,
+		Sys:        m.Sys,
+		NumGC:      m.NumGC,
+	}
+}
+
+func (s RuntimeStats) Format(format LogFormat) string {
+	if format == LogFormatJSON {
+		b, _ := json.Marshal(s)
+		return string(b)
+	}
+	if format == LogFormatLogfmt {
+		return logfmtString(
+			"goroutines", s.Goroutines, "heap_alloc", s.HeapAlloc,
+			"heap_inuse", s.HeapInuse, "sys", s.Sys, "num_gc", s.NumGC,
+		)
+	}
+	sep := logTheme.Sep()
+	return fmt.Sprintf(
+		"%s goroutines %d %s heap_alloc %d %s heap_inuse %d %s sys %d %s num_gc %d",
+		sep, s.Goroutines, sep, s.HeapAlloc, sep, s.HeapInuse, sep, s.Sys, sep, s.NumGC,
+	)
+}
+
+// ReportRuntimeStats logs the goroutine count and memory stats every
+// interval until ctx is done, to spot leaks where pprof isn't enabled.
+func ReportRuntimeStats(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			errorLog.Print(LevelInfo, ReadRuntimeStats())
+		}
+	}
+}
+
+// RuntimeVarsHandler serves the current RuntimeStats as JSON
+func RuntimeVarsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, ReadRuntimeStats())
+}
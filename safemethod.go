@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// sideEffects lists what a 2xx response to a GET or HEAD shows of a state
+// change: a 201, cookies set, other than allowed ones, or any of headers
+func sideEffects(status int, header http.Header, allowedCookies map[string]bool, headers []string) []string {
+	if status < 200 || status >= 300 {
+		return nil
+	}
+	effects := []string{}
+	if status == http.StatusCreated {
+		effects = append(effects, "201 Created")
+	}
+	for _, cookie := range (&http.Response{Header: header}).Cookies() {
+		if !allowedCookies[cookie.Name] {
+			effects = append(effects, "sets cookie "+cookie.Name)
+		}
+	}
+	for _, name := range headers {
+		if header.Get(name) != "" {
+			effects = append(effects, "sends "+http.CanonicalHeaderKey(name))
+		}
+	}
+	return effects
+}
+
+// SafeMethodCheckMiddleware is a development aid warning about GET and HEAD
+// requests, which should never change state, answered like ones that did:
+// a 2xx with a 201 status, cookies set or any of headers, like a Location
+// or an X-Created-ID. allowedCookies are set on GETs on purpose, like the
+// CSRF token. It only warns, the response goes out unchanged.
+func SafeMethodCheckMiddleware(headers, allowedCookies []string) mux.MiddlewareFunc {
+	allowed := map[string]bool{}
+	for _, name := range allowedCookies {
+		allowed[name] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			writer, ok := w.(*ResponseRecorderWriter)
+			if !ok {
+				writer = &ResponseRecorderWriter{ResponseWriter: w, Status: http.StatusOK}
+			}
+			writer.OnWriteHeader(func(status int, header http.Header) {
+				if effects := sideEffects(status, header, allowed, headers); len(effects) > 0 {
+					LoggerFromContext(r).Printf(
+						"%s on route %s looks like it changed state: %s",
+						r.Method, RouteName(r), strings.Join(effects, ", "),
+					)
+				}
+			})
+
+			next.ServeHTTP(writer, r)
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const SequenceHeader string = "X-Sequence"
+
+type sessionSequence struct {
+	session string
+	last    uint64
+	at      time.Time
+}
+
+// SequenceTracker remembers the last sequence number of each session for
+// ttl since its latest request, at most size sessions, the least recently
+// seen being forgotten first
+type SequenceTracker struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	size      int
+	tolerance uint64
+	sessions  map[string]*list.Element
+	order     *list.List
+}
+
+func NewSequenceTracker(ttl time.Duration, size, tolerance int) *SequenceTracker {
+	return &SequenceTracker{
+		ttl:       ttl,
+		size:      size,
+		tolerance: uint64(tolerance),
+		sessions:  map[string]*list.Element{},
+		order:     list.New(),
+	}
+}
+
+// Check accepts seq when it follows the session's last one, skipping at
+// most tolerance numbers, and records it. A session's first number, or its
+// first once forgotten, is always accepted.
+func (st *SequenceTracker) Check(session string, seq uint64) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	now := time.Now()
+	for e := st.order.Back(); e != nil && now.Sub(e.Value.(*sessionSequence).at) > st.ttl; e = st.order.Back() {
+		st.evict(e)
+	}
+
+	e, ok := st.sessions[session]
+	if !ok {
+		if st.order.Len() >= st.size {
+			st.evict(st.order.Back())
+		}
+		st.sessions[session] = st.order.PushFront(&sessionSequence{session, seq, now})
+		return nil
+	}
+
+	s := e.Value.(*sessionSequence)
+	switch {
+	case seq <= s.last:
+		return fmt.Errorf("sequence %d not after %d", seq, s.last)
+	case seq-s.last-1 > st.tolerance:
+		return fmt.Errorf("sequence %d skips %d after %d", seq, seq-s.last-1, s.last)
+	}
+	s.last, s.at = seq, now
+	st.order.MoveToFront(e)
+	return nil
+}
+
+func (st *SequenceTracker) evict(e *list.Element) {
+	delete(st.sessions, e.Value.(*sessionSequence).session)
+	st.order.Remove(e)
+}
+
+// SequenceMiddleware answers a 409 to requests whose X-Sequence goes back
+// or skips ahead of their session's, the session being named by the
+// sessionHeader. Requests missing either header pass through unchecked. A
+// nil st disables it.
+func SequenceMiddleware(st *SequenceTracker, sessionHeader string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		if st == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			session, value := r.Header.Get(sessionHeader), r.Header.Get(SequenceHeader)
+			if session == "" || value == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			seq, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				Reject(w, r, http.StatusBadRequest, "invalid "+SequenceHeader+" "+strconv.Quote(value))
+				return
+			}
+			if err := st.Check(session, seq); err != nil {
+				Reject(w, r, http.StatusConflict, "session "+strconv.Quote(session)+" "+err.Error())
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
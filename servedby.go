@@ -0,0 +1,20 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+const ServedByHeader string = "X-Served-By"
+
+func ServedByMiddleware(name string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if name != "" {
+				w.Header().Set(ServedByHeader, name)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
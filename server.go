@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const unixAddrPrefix string = "unix:"
+
+// SocketPath returns the socket file of an unix:/path/to/sock address
+func SocketPath(addr string) (string, bool) {
+	return strings.CutPrefix(addr, unixAddrPrefix)
+}
+
+func ParseSocketMode(mode string) (os.FileMode, error) {
+	m, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || m > 0o777 {
+		return 0, fmt.Errorf("config: invalid socket mode %q", mode)
+	}
+	return os.FileMode(m), nil
+}
+
+// ListenUnix listens on a socket file with the given permissions, replacing a
+// stale socket left behind by a previous run but nothing else.
+func ListenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	info, err := os.Lstat(path)
+	switch {
+	case err == nil && info.Mode()&os.ModeSocket == 0:
+		return nil, fmt.Errorf("listen: %s exists and isn't a socket", path)
+	case err == nil:
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, err
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// RemoveSocket deletes the socket file, if cfg listens on one
+func RemoveSocket(cfg *Config) error {
+	if path, ok := SocketPath(cfg.Addr); ok {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Listen binds cfg.Addr, a TCP address or an unix: socket, so failing to is
+// reported before serving starts. At most MAX_CONNECTIONS are accepted at
+// once.
+func Listen(cfg *Config) (net.Listener, error) {
+	l, err := listen(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return LimitListener(l, cfg.MaxConnections), nil
+}
+
+func listen(cfg *Config) (net.Listener, error) {
+	path, unix := SocketPath(cfg.Addr)
+	if !unix {
+		return net.Listen("tcp", cfg.Addr)
+	}
+
+	mode, err := ParseSocketMode(cfg.SocketMode)
+	if err != nil {
+		return nil, err
+	}
+	return ListenUnix(path, mode)
+}
+
+// BoundAddr is the address l listens on, with the port the system picked
+// when cfg.Addr asked for port 0, like 127.0.0.1:0
+func BoundAddr(l net.Listener, cfg *Config) string {
+	if _, unix := SocketPath(cfg.Addr); unix {
+		return cfg.Addr
+	}
+	return l.Addr().String()
+}
+
+// Start binds cfg.Addr and serves srv on it in the background, returning
+// the bound address as BoundAddr tells it, so callers listening on port 0,
+// like tests, know where to send requests. srv is closed, abruptly, once
+// ctx is done; main passes one that never is and shuts down gracefully.
+func Start(ctx context.Context, srv *http.Server, cfg *Config) (string, error) {
+	listener, err := Listen(cfg)
+	if err != nil {
+		return "", err
+	}
+	go func() {
+		if err := Serve(srv, listener, cfg); err != nil {
+			log.Println(err)
+		}
+	}()
+	context.AfterFunc(ctx, func() { srv.Close() })
+	return BoundAddr(listener, cfg), nil
+}
+
+// Serve blocks serving srv on l, over TLS when a certificate is set, which
+// srv.TLSConfig.GetCertificate must then serve, like CertReloader's
+func Serve(srv *http.Server, l net.Listener, cfg *Config) error {
+	if cert, _ := cfg.TLSFiles(); cert != "" {
+		return srv.ServeTLS(l, "", "")
+	}
+	return srv.Serve(l)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var shutdownCtx, cancelShutdown = context.WithCancel(context.Background())
+
+// ShutdownContext is cancelled as soon as the server starts shutting down.
+// srv.Shutdown only waits for handlers to return, so long lived handlers
+// (SSE, websockets) should select on it and close their streams, see
+// EventsHandler.
+func ShutdownContext() context.Context {
+	return shutdownCtx
+}
+
+const ReadyPath string = "/readyz"
+
+// ready gates ReadyHandler, it's set once serving and cleared first thing on
+// shutdown so load balancers stop sending traffic before it's refused
+var ready atomic.Bool
+
+func ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if !ready.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("draining"))
+		return
+	}
+	w.Write([]byte("ok"))
+}
+
+type shutdownHook struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+var (
+	shutdownHooksMu sync.Mutex
+	shutdownHooks   []shutdownHook
+)
+
+// OnShutdown registers fn to release a resource once the server is drained.
+// Hooks run in registration order, each with its own timeout, and should
+// return when their ctx is done.
+func OnShutdown(name string, fn func(ctx context.Context) error) {
+	shutdownHooksMu.Lock()
+	defer shutdownHooksMu.Unlock()
+	shutdownHooks = append(shutdownHooks, shutdownHook{name, fn})
+}
+
+// ShutdownTimeouts bound each shutdown phase on its own, so a slow one
+// doesn't eat into the others' time
+type ShutdownTimeouts struct {
+	// ReadinessDelay is how long to keep serving once not ready
+	ReadinessDelay time.Duration
+	// Drain bounds srv.Shutdown waiting for in flight requests
+	Drain time.Duration
+	// Hook bounds every shutdown hook
+	Hook time.Duration
+}
+
+// Shutdown stops srv in phases, logging how each one went: it turns not
+// ready and keeps serving for the readiness delay, drains the in flight
+// requests, then runs the shutdown hooks.
+func Shutdown(srv *http.Server, timeouts ShutdownTimeouts) {
+	ready.Store(false)
+	if timeouts.ReadinessDelay > 0 {
+		log.Printf("| Shutdown: not ready, serving %s more", timeouts.ReadinessDelay)
+		time.Sleep(timeouts.ReadinessDelay)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeouts.Drain)
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("| Shutdown: drain gave up after %s: %s", timeouts.Drain, err)
+	} else {
+		log.Println("| Shutdown: drained")
+	}
+	cancel()
+
+	shutdownHooksMu.Lock()
+	hooks := append([]shutdownHook{}, shutdownHooks...)
+	shutdownHooksMu.Unlock()
+
+	for _, hook := range hooks {
+		runShutdownHook(hook, timeouts.Hook)
+	}
+}
+
+// runShutdownHook gives up waiting on a hook that overruns its timeout, it
+// may still be running when the process exits
+func runShutdownHook(hook shutdownHook, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- hook.fn(ctx) }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			log.Printf("| Shutdown: %s failed: %s", hook.name, err)
+		} else {
+			log.Printf("| Shutdown: %s done", hook.name)
+		}
+	case <-ctx.Done():
+		log.Printf("| Shutdown: %s gave up after %s", hook.name, timeout)
+	}
+}
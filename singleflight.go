@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/sync/singleflight"
+)
+
+// flightWriter passes the leading request's response through while keeping
+// a copy, up to max bytes, for the requests waiting on it. The headers are
+// copied as the handler starts the response, before outer writers like gzip
+// add their own.
+type flightWriter struct {
+	http.ResponseWriter
+	max      int
+	status   int
+	header   http.Header
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (fw *flightWriter) start(status int) {
+	if fw.status == 0 {
+		fw.status = status
+		fw.header = fw.Header().Clone()
+	}
+}
+
+func (fw *flightWriter) WriteHeader(status int) {
+	if status >= 200 {
+		fw.start(status)
+	}
+	fw.ResponseWriter.WriteHeader(status)
+}
+
+func (fw *flightWriter) Write(b []byte) (int, error) {
+	fw.start(http.StatusOK)
+	if !fw.overflow {
+		if fw.body.Len()+len(b) > fw.max {
+			fw.overflow = true
+			fw.body = bytes.Buffer{}
+		} else {
+			fw.body.Write(b)
+		}
+	}
+	return fw.ResponseWriter.Write(b)
+}
+
+func (fw *flightWriter) Unwrap() http.ResponseWriter {
+	return fw.ResponseWriter
+}
+
+// flight is a response shared with the waiters, nil when it can't be. vary
+// holds the leading request's values of the headers in the response's Vary.
+type flight struct {
+	leader string
+	status int
+	header http.Header
+	body   []byte
+	vary   map[string]string
+}
+
+// negotiated are the request headers always part of a flight's key, the
+// ones responses usually vary on
+var negotiated []string = []string{"Accept", "Accept-Encoding", "Accept-Language"}
+
+// flightKey tells apart requests for the same resource that may be given
+// different representations
+func flightKey(r *http.Request) string {
+	key := r.Method + " " + r.URL.RequestURI()
+	for _, name := range negotiated {
+		key += "\n" + strings.Join(r.Header.Values(name), ",")
+	}
+	return key
+}
+
+// varied returns r's values of the headers named in the Vary of header,
+// false when it varies on anything
+func varied(header http.Header, r *http.Request) (map[string]string, bool) {
+	vary := map[string]string{}
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "*" {
+				return nil, false
+			}
+			if name != "" {
+				vary[name] = strings.Join(r.Header.Values(name), ",")
+			}
+		}
+	}
+	return vary, true
+}
+
+// matches tells whether r has the leading request's values of the headers
+// the response varies on
+func (f *flight) matches(r *http.Request) bool {
+	for name, value := range f.vary {
+		if strings.Join(r.Header.Values(name), ",") != value {
+			return false
+		}
+	}
+	return true
+}
+
+// shareable tells whether a response may be handed to other clients: it's
+// whole, sets no cookie and isn't meant for the leading client alone
+func shareable(fw *flightWriter) bool {
+	if fw.overflow || fw.status == 0 || fw.header.Get("Set-Cookie") != "" {
+		return false
+	}
+	cc := strings.ToLower(fw.header.Get("Cache-Control"))
+	return !strings.Contains(cc, "private") && !strings.Contains(cc, "no-store")
+}
+
+// SingleFlightMiddleware runs the handler of routes once for concurrent
+// identical GET and HEAD requests, keyed by method, path, query and the
+// Accept headers, the waiters getting a copy of the response. A waiter
+// differing from the leading request in a header the response's Vary names
+// isn't given it. Requests with credentials are
+// never coalesced. When the response is over maxBody bytes, or not meant to
+// be shared, the waiters run the handler themselves.
+func SingleFlightMiddleware(routes []string, maxBody int) mux.MiddlewareFunc {
+	var (
+		group   singleflight.Group
+		enabled = map[string]bool{}
+	)
+	for _, route := range routes {
+		enabled[route] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		if len(enabled) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !enabled[RouteName(r)] ||
+				(r.Method != http.MethodGet && r.Method != http.MethodHead) ||
+				r.Header.Get("Authorization") != "" || r.Header.Get("Cookie") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			led := false
+			v, _, shared := group.Do(flightKey(r), func() (interface{}, error) {
+				led = true
+
+				// Only what the handler sets is shared, not what outer
+				// middlewares set for this request alone
+				before := w.Header().Clone()
+				fw := &flightWriter{ResponseWriter: w, max: maxBody}
+				next.ServeHTTP(fw, r)
+
+				if !shareable(fw) || r.Context().Err() != nil {
+					return (*flight)(nil), nil
+				}
+				vary, ok := varied(fw.header, r)
+				if !ok {
+					return (*flight)(nil), nil
+				}
+				header := http.Header{}
+				for key, values := range fw.header {
+					if strings.Join(before[key], ",") != strings.Join(values, ",") {
+						header[key] = values
+					}
+				}
+				return &flight{RequestIDFromContext(r), fw.status, header, fw.body.Bytes(), vary}, nil
+			})
+			if led {
+				return
+			}
+
+			f := v.(*flight)
+			if !shared || f == nil || !f.matches(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			LoggerFromContext(r).Printf("coalesced with request %s", f.leader)
+			for key, values := range f.header {
+				w.Header()[key] = values
+			}
+			w.WriteHeader(f.status)
+			w.Write(f.body)
+		})
+	}
+}
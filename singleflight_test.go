@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestSingleFlightVary(t *testing.T) {
+	captureStdLog(t)
+	captureLog(t, errorLog)
+
+	release := make(chan struct{})
+	var (
+		mu    sync.Mutex
+		calls int
+	)
+	router := mux.NewRouter()
+	router.Use(SingleFlightMiddleware([]string{"items"}, 1024))
+	Handle(router, "items", "/items", []string{"GET"}, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		<-release
+		w.Header().Set("Vary", "X-Tenant")
+		fmt.Fprintf(w, "%s %s", r.Header.Get("Accept"), r.Header.Get("X-Tenant"))
+	})
+
+	for _, tt := range []struct {
+		name   string
+		second http.Header
+		want   string
+		calls  int
+	}{
+		{"same headers", http.Header{"Accept": {"text/plain"}, "X-Tenant": {"a"}}, "text/plain a", 1},
+		{"other Accept", http.Header{"Accept": {"application/json"}, "X-Tenant": {"a"}}, "application/json a", 2},
+		{"other varied header", http.Header{"Accept": {"text/plain"}, "X-Tenant": {"b"}}, "text/plain b", 2},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			release = make(chan struct{})
+			calls = 0
+
+			var wg sync.WaitGroup
+			bodies := make([]string, 2)
+			for i, header := range []http.Header{{"Accept": {"text/plain"}, "X-Tenant": {"a"}}, tt.second} {
+				wg.Add(1)
+				go func(i int, header http.Header) {
+					defer wg.Done()
+					r := httptest.NewRequest(http.MethodGet, "/items", nil)
+					r.Header = header
+					w := httptest.NewRecorder()
+					router.ServeHTTP(w, r)
+					bodies[i] = w.Body.String()
+				}(i, header)
+				// So the first one leads
+				time.Sleep(10 * time.Millisecond)
+			}
+			close(release)
+			wg.Wait()
+
+			if bodies[0] != "text/plain a" || bodies[1] != tt.want {
+				t.Errorf("got %q and %q, want %q and %q", bodies[0], bodies[1], "text/plain a", tt.want)
+			}
+			if calls != tt.calls {
+				t.Errorf("handler ran %d times, want %d", calls, tt.calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+)
+
+// Log backends: the log package's lines in LOG_FORMAT, or slog records
+const (
+	LogBackendLog  string = "log"
+	LogBackendSlog string = "slog"
+)
+
+func ValidateLogBackend(backend string) error {
+	if backend != LogBackendLog && backend != LogBackendSlog {
+		return fmt.Errorf("unknown log backend %q, expected log or slog", backend)
+	}
+	return nil
+}
+
+func slogLevel(level LogLevel) slog.Level {
+	switch level {
+	case LevelDebug:
+		return slog.LevelDebug
+	case LevelWarn:
+		return slog.LevelWarn
+	case LevelError:
+		return slog.LevelError
+	}
+	return slog.LevelInfo
+}
+
+// SetSlog routes the logger's entries through s as structured records, its
+// format and precision then left to s's handler. A nil s goes back to the
+// log package.
+func (l *Logger) SetSlog(s *slog.Logger) {
+	l.slog.Store(s)
+}
+
+// UseSlog routes the access and error logs through s, their records told
+// apart by a log attribute, for services embedding the server to log
+// through their own handlers
+func UseSlog(s *slog.Logger) {
+	accessLog.SetSlog(s.With("log", "access"))
+	errorLog.SetSlog(s.With("log", "error"))
+}
+
+// slogRecord is entry as a message and attributes. Access lines keep their
+// typed fields, durations included, under the request message, or their
+// own for panics and rejections. Messages logged for a request are theirs,
+// with its ID, method and path. Other entries, like error rate alerts, get
+// their JSON fields under their type's name.
+func slogRecord(entry formatter) (string, []slog.Attr) {
+	if rl, ok := entry.(*RequestLogger); ok {
+		message := "request"
+		if rl.GetMessage() != "" {
+			message = rl.GetMessage()
+		}
+		attrs := []slog.Attr{}
+		for _, f := range rl.fields() {
+			if f.Key != "message" {
+				attrs = append(attrs, slog.Any(f.Key, f.Value))
+			}
+		}
+		return message, attrs
+	}
+	if m, ok := entry.(RequestMessage); ok {
+		return m.Message, []slog.Attr{
+			slog.String("id", m.RequestID),
+			slog.String("method", m.Method),
+			slog.String("path", m.Path),
+		}
+	}
+
+	message := strings.TrimPrefix(fmt.Sprintf("%T", entry), "main.")
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(entry.Format(LogFormatJSON)), &fields); err != nil {
+		return message, []slog.Attr{slog.String("entry", entry.Format(LogFormatLogfmt))}
+	}
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	attrs := make([]slog.Attr, len(keys))
+	for i, key := range keys {
+		attrs[i] = slog.Any(key, fields[key])
+	}
+	return message, attrs
+}
+
+func printSlog(s *slog.Logger, level LogLevel, entry formatter) {
+	message, attrs := slogRecord(entry)
+	s.LogAttrs(context.Background(), slogLevel(level), message, attrs...)
+}
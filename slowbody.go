@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// timedBody adds up the time spent blocked in Read, waiting on the client,
+// and the bytes it got. Like countingBody it may still be read from the
+// handler's goroutine when checked.
+type timedBody struct {
+	io.ReadCloser
+	n       atomic.Int64
+	blocked atomic.Int64
+}
+
+func (b *timedBody) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := b.ReadCloser.Read(p)
+	b.blocked.Add(int64(time.Since(start)))
+	b.n.Add(int64(n))
+	return n, err
+}
+
+// SlowBodyMiddleware logs a warning when reading a request's body kept the
+// handler waiting on the client for longer than threshold, so slow uploads
+// aren't mistaken for slow handlers when looking at latencies. A threshold of
+// 0 disables it.
+func SlowBodyMiddleware(threshold time.Duration) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		if threshold <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Body == nil || r.Body == http.NoBody {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			body := &timedBody{ReadCloser: r.Body}
+			r.Body = body
+			next.ServeHTTP(w, r)
+
+			if blocked := time.Duration(body.blocked.Load()); blocked > threshold {
+				LoggerFromContext(r).Printf(
+					"%sslow client%s reading %d body bytes took %s, over %s",
+					colors.Yellow, colors.Reset, body.n.Load(), blocked.Round(time.Millisecond), threshold,
+				)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/gorilla/mux"
+)
+
+// StatusRewrite answers Status instead of whatever the handler did when its
+// body matches Pattern, for routes relaying a dependency that reports errors
+// with a 200
+type StatusRewrite struct {
+	Pattern string `json:"pattern"`
+	Status  int    `json:"status"`
+
+	pattern *regexp.Regexp
+}
+
+// StatusRewrites are keyed by route name. From env and flags they read as
+// the same JSON object as in the config file.
+type StatusRewrites map[string]StatusRewrite
+
+func (s *StatusRewrites) Set(v string) error {
+	rewrites := StatusRewrites{}
+	if err := json.Unmarshal([]byte(v), &rewrites); err != nil {
+		return err
+	}
+	*s = rewrites
+	return nil
+}
+
+func (s *StatusRewrites) String() string {
+	b, _ := json.Marshal(*s)
+	return string(b)
+}
+
+// Compile checks the rewrites and compiles their patterns
+func (s StatusRewrites) Compile() (StatusRewrites, error) {
+	compiled := StatusRewrites{}
+	for route, rw := range s {
+		pattern, err := regexp.Compile(rw.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("config: status rewrite of route %s: %w", route, err)
+		}
+		if rw.Status < 200 || rw.Status > 599 {
+			return nil, fmt.Errorf("config: status rewrite of route %s: status %d out of 200-599", route, rw.Status)
+		}
+		rw.pattern = pattern
+		compiled[route] = rw
+	}
+	return compiled, nil
+}
+
+// rewriteWriter buffers the response up to max bytes so its status can
+// still change once the body is known. Past max, or when flushed, it stops
+// buffering and the response goes out unchanged.
+type rewriteWriter struct {
+	http.ResponseWriter
+	max         int
+	status      int
+	body        bytes.Buffer
+	passthrough bool
+}
+
+func (rw *rewriteWriter) WriteHeader(status int) {
+	if status < 200 || rw.passthrough {
+		rw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if rw.status == 0 {
+		rw.status = status
+	}
+}
+
+func (rw *rewriteWriter) Write(b []byte) (int, error) {
+	if !rw.passthrough && rw.body.Len()+len(b) > rw.max {
+		rw.release()
+	}
+	if rw.passthrough {
+		return rw.ResponseWriter.Write(b)
+	}
+	return rw.body.Write(b)
+}
+
+func (rw *rewriteWriter) Flush() {
+	rw.release()
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// release sends what was buffered as is and stops buffering
+func (rw *rewriteWriter) release() {
+	if rw.passthrough {
+		return
+	}
+	rw.passthrough = true
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	rw.ResponseWriter.WriteHeader(rw.status)
+	rw.ResponseWriter.Write(rw.body.Bytes())
+}
+
+func (rw *rewriteWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// StatusRewriteMiddleware applies the rewrite of the matched route, if it has
+// one, to responses of at most maxBody bytes, logging every status it changes
+func StatusRewriteMiddleware(rewrites StatusRewrites, maxBody int) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		if len(rewrites) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rewrite, ok := rewrites[RouteName(r)]
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			rw := &rewriteWriter{ResponseWriter: w, max: maxBody}
+			next.ServeHTTP(rw, r)
+			if rw.passthrough {
+				return
+			}
+
+			if rw.status == 0 {
+				rw.status = http.StatusOK
+			}
+			if rw.status != rewrite.Status && rewrite.pattern.Match(rw.body.Bytes()) {
+				LoggerFromContext(r).Printf(
+					"%sstatus rewritten%s from %d to %d, the body matched %q",
+					colors.Yellow, colors.Reset, rw.status, rewrite.Status, rewrite.Pattern,
+				)
+				rw.status = rewrite.Status
+			}
+			rw.release()
+		})
+	}
+}
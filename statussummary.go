@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// StatusCounters counts responses per status class, indexed by status/100
+type StatusCounters struct {
+	classes [6]atomic.Uint64
+}
+
+var statusCounters *StatusCounters = &StatusCounters{}
+
+func (sc *StatusCounters) Observe(status int) {
+	if class := status / 100; class >= 0 && class < len(sc.classes) {
+		sc.classes[class].Add(1)
+	}
+}
+
+// Reset returns the counts since the previous Reset and starts over
+func (sc *StatusCounters) Reset() [6]uint64 {
+	var counts [6]uint64
+	for i := range sc.classes {
+		counts[i] = sc.classes[i].Swap(0)
+	}
+	return counts
+}
+
+type StatusSummary struct {
+	Counts   [6]uint64
+	Interval time.Duration
+}
+
+func (s StatusSummary) Format(format LogFormat) string {
+	if format == LogFormatJSON {
+		return fmt.Sprintf(
+			`{"summary_ms":%g,"2xx":%d,"3xx":%d,"4xx":%d,"5xx":%d}`,
+			float64(s.Interval)/float64(time.Millisecond),
+			s.Counts[2], s.Counts[3], s.Counts[4], s.Counts[5],
+		)
+	}
+	if format == LogFormatLogfmt {
+		return logfmtString(
+			"summary", s.Interval, "2xx", s.Counts[2], "3xx", s.Counts[3],
+			"4xx", s.Counts[4], "5xx", s.Counts[5],
+		)
+	}
+	sep := logTheme.Sep()
+	return fmt.Sprintf(
+		"%s %s2xx%s %d %s %s3xx%s %d %s %s4xx%s %d %s %s5xx%s %d %s last %s",
+		sep, colors.Green, colors.Reset, s.Counts[2],
+		sep, colors.Yellow, colors.Reset, s.Counts[3],
+		sep, colors.Magenta, colors.Reset, s.Counts[4],
+		sep, colors.Red, colors.Reset, s.Counts[5],
+		sep, s.Interval,
+	)
+}
+
+// ReportStatusSummary logs the per class counts every interval until ctx is
+// done.
+func ReportStatusSummary(ctx context.Context, sc *StatusCounters, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			accessLog.Print(LevelInfo, StatusSummary{sc.Reset(), interval})
+		}
+	}
+}
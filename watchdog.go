@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// watchdogWriter buffers the handler's response so it can be dropped when the
+// handler overruns its deadline, after which its writes fail.
+type watchdogWriter struct {
+	mu       sync.Mutex
+	header   http.Header
+	body     bytes.Buffer
+	status   int
+	timedOut bool
+}
+
+func (ww *watchdogWriter) Header() http.Header {
+	return ww.header
+}
+
+func (ww *watchdogWriter) WriteHeader(status int) {
+	ww.mu.Lock()
+	defer ww.mu.Unlock()
+	if ww.status == 0 && !ww.timedOut {
+		ww.status = status
+	}
+}
+
+func (ww *watchdogWriter) Write(b []byte) (int, error) {
+	ww.mu.Lock()
+	defer ww.mu.Unlock()
+	if ww.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if ww.status == 0 {
+		ww.status = http.StatusOK
+	}
+	return ww.body.Write(b)
+}
+
+// errWatchdogDeadline is the cause of the watchdog's own deadline, told
+// apart from an earlier one of the request's, like the write deadline's
+var errWatchdogDeadline error = errors.New("watchdog deadline exceeded")
+
+// WatchdogMiddleware bounds how long a handler may run, which the server's
+// Read/WriteTimeout don't. The handler runs in its own goroutine with a
+// context deadline of timeout, or routeTimeouts[route name] when set, zero
+// disabling it. On overrun the client gets a 503 and the buffered response
+// is dropped, but Go can't stop a goroutine: the handler keeps running until
+// it notices its context is done or returns on its own.
+//
+// Buffering means streaming handlers (like EventsHandler) can't flush, so
+// their routes need a zero timeout.
+func WatchdogMiddleware(timeout time.Duration, routeTimeouts map[string]Duration) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			deadline := timeout
+			if route := mux.CurrentRoute(r); route != nil {
+				if t, ok := routeTimeouts[route.GetName()]; ok {
+					deadline = time.Duration(t)
+				}
+			}
+			if deadline <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx, cancel := context.WithTimeoutCause(r.Context(), deadline, errWatchdogDeadline)
+			defer cancel()
+
+			var (
+				ww       *watchdogWriter    = &watchdogWriter{header: w.Header().Clone()}
+				done     chan struct{}      = make(chan struct{})
+				panicked chan reraisedPanic = make(chan reraisedPanic, 1)
+			)
+
+			go func() {
+				defer func() {
+					if err := recover(); err != nil {
+						panicked <- reraisedPanic{value: err, stack: debug.Stack()}
+					}
+				}()
+				next.ServeHTTP(ww, r.WithContext(ctx))
+				close(done)
+			}()
+
+			select {
+			case p := <-panicked:
+				// Re-raised here so RecoveryMiddleware catches it, with the
+				// handler's stack
+				reraise(p.value, p.stack)
+			case <-done:
+				ww.mu.Lock()
+				defer ww.mu.Unlock()
+
+				// Replaced whole, so the headers the handler deleted stay so
+				dst := w.Header()
+				for k := range dst {
+					delete(dst, k)
+				}
+				for k, v := range ww.header {
+					dst[k] = v
+				}
+				if ww.status != 0 {
+					w.WriteHeader(ww.status)
+				}
+				w.Write(ww.body.Bytes())
+			case <-ctx.Done():
+				ww.mu.Lock()
+				ww.timedOut = true
+				ww.mu.Unlock()
+
+				// Nobody is left to answer when the client went away
+				if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+					return
+				}
+				whose, fired := "its", deadline
+				if !errors.Is(context.Cause(ctx), errWatchdogDeadline) {
+					parent, _ := r.Context().Deadline()
+					whose, fired = "the request's", parent.Sub(RequestStart(r)).Round(time.Millisecond)
+				}
+				LoggerFromContext(r).Printf(
+					"%shandler overran %s %s deadline and may still be running%s",
+					colors.Red, whose, fired, colors.Reset,
+				)
+				writeError(w, r, http.StatusServiceUnavailable, http.StatusText(http.StatusServiceUnavailable))
+			}
+		})
+	}
+}
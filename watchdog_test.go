@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func explodingHandler(w http.ResponseWriter, r *http.Request) {
+	panic("exploded under the watchdog")
+}
+
+func TestWatchdogPanicStack(t *testing.T) {
+	errs := captureLog(t, errorLog)
+	captureLog(t, accessLog)
+	saved := panicStacks
+	panicStacks = true
+	t.Cleanup(func() { panicStacks = saved })
+
+	router := mux.NewRouter()
+	router.Use(RecoveryMiddleware, LoggerMiddleware, WatchdogMiddleware(time.Second, nil))
+	Handle(router, "explode", "/explode", []string{"GET"}, explodingHandler)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/explode", nil))
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want 500", w.Code)
+	}
+	lines := errs.Lines(t)
+	if len(lines) != 1 {
+		t.Fatalf("got error lines %v, want the panic", lines)
+	}
+	if lines[0]["panic_type"] != "string" || lines[0]["message"] != "exploded under the watchdog" {
+		t.Errorf("got panic %v: %v, want the handler's string", lines[0]["panic_type"], lines[0]["message"])
+	}
+	if stack, _ := lines[0]["panic_stack"].(string); !strings.Contains(stack, "reststd.explodingHandler") {
+		t.Errorf("got stack %q, want the handler's", stack)
+	}
+}
+
+func TestWatchdogHeaders(t *testing.T) {
+	handler := WatchdogMiddleware(time.Second, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Del("X-Outer")
+		w.Header().Set("X-Inner", "1")
+		w.Write([]byte("ok"))
+	}))
+
+	w := httptest.NewRecorder()
+	w.Header().Set("X-Outer", "1")
+	w.Header().Set("X-Kept", "1")
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if v := w.Header().Get("X-Outer"); v != "" {
+		t.Errorf("deleted X-Outer came back as %q", v)
+	}
+	if w.Header().Get("X-Inner") != "1" || w.Header().Get("X-Kept") != "1" {
+		t.Errorf("got headers %v, want X-Inner and X-Kept", w.Header())
+	}
+	if w.Body.String() != "ok" {
+		t.Errorf("got body %q", w.Body.String())
+	}
+}
+
+func TestWatchdogDeadlineLogged(t *testing.T) {
+	tests := []struct {
+		name   string
+		parent time.Duration
+		want   string
+	}{
+		{"own deadline", 0, "handler overran its 20ms deadline"},
+		{"earlier request deadline", 10 * time.Millisecond, "handler overran the request's 10ms deadline"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := captureLog(t, errorLog)
+			handler := WatchdogMiddleware(20*time.Millisecond, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				<-r.Context().Done()
+			}))
+			if tt.parent > 0 {
+				handler = WriteDeadlineMiddleware(tt.parent, 0, nil)(handler)
+			}
+			handler = RequestIDMiddleware(handler)
+
+			r := httptest.NewRequest(http.MethodGet, "/slow", nil)
+			r.Header.Set("Accept", ProblemContentType)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != http.StatusServiceUnavailable || w.Header().Get("Content-Type") != ProblemContentType {
+				t.Errorf("got %d as %q, want a 503 problem", w.Code, w.Header().Get("Content-Type"))
+			}
+			lines := errs.Lines(t)
+			if len(lines) != 1 || !strings.HasPrefix(lines[0]["message"].(string), colors.Red+tt.want) {
+				t.Errorf("got lines %v, want %q", lines, tt.want)
+			}
+		})
+	}
+}
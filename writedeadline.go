@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// WriteDeadlineMiddleware gives handlers a context deadline margin short of
+// the server's writeTimeout, counted from the request start, so they can
+// give up on work whose response could never be written and still have time
+// to answer. Routes with a zero entry in routeTimeouts stream past the write
+// timeout, see EventsHandler, so they're left alone.
+func WriteDeadlineMiddleware(writeTimeout, margin time.Duration, routeTimeouts map[string]Duration) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		if writeTimeout <= margin {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if t, ok := routeTimeouts[RouteName(r)]; ok && t == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx, cancel := context.WithDeadline(r.Context(), RequestStart(r).Add(writeTimeout-margin))
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}